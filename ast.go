@@ -4,6 +4,10 @@ type Program []Toplevel
 
 type Toplevel interface {
 	FormattableCode
+	// Declare the types defined by the toplevel
+	DeclareTypes(c *Compiler)
+	// Declare the variables and functions defined by the toplevel
+	DeclareVars(c *Compiler)
 	GenToplevel(c *Compiler)
 }
 
@@ -13,11 +17,13 @@ type NamespaceTL struct {
 }
 
 type Function struct {
-	Pub   bool
-	Name  string
-	Param []VarDecl
-	Ret   TypeExpr
-	Body  []Statement
+	Pub       bool
+	Recv      *VarDecl // Receiver of a method; nil for plain functions
+	Name      string
+	TypeParam []string // Type parameters of a generic function
+	Param     []VarDecl
+	Ret       TypeExpr
+	Body      []Statement
 }
 
 type VarDecl struct {
@@ -28,6 +34,7 @@ type VarsDecl struct {
 	Extern bool
 	Names  []string
 	Ty     TypeExpr
+	Init   []Expression // One value per name, or nil
 }
 
 func (d VarsDecl) Decls() []VarDecl {
@@ -38,9 +45,16 @@ func (d VarsDecl) Decls() []VarDecl {
 	return ds
 }
 
+type ConstDecl struct {
+	Name  string
+	Ty    TypeExpr // Optional
+	Value Expression
+}
+
 type TypeDef struct {
-	Name string
-	Ty   TypeExpr
+	Name      string
+	TypeParam []string // Type parameters of a generic type
+	Ty        TypeExpr
 }
 type TypeAlias struct {
 	Name string
@@ -52,6 +66,8 @@ type Statement interface {
 	GenStatement(c *Compiler)
 }
 
+type BlockStmt []Statement
+
 type IfStmt struct {
 	Cond       Expression
 	Then, Else []Statement
@@ -62,6 +78,25 @@ type ForStmt struct {
 	Body       []Statement
 }
 
+type SwitchStmt struct {
+	Value Expression
+	Cases []SwitchCase
+}
+type SwitchCase struct {
+	Values []Expression // nil for the default case
+	Body   []Statement
+}
+
+type MatchStmt struct {
+	Value Expression
+	Cases []MatchCase
+}
+type MatchCase struct {
+	Variant string // Empty for the default case
+	Bind    string // Optional name bound to the payload
+	Body    []Statement
+}
+
 type BreakStmt struct{}
 type ContinueStmt struct{}
 
@@ -69,6 +104,12 @@ type ReturnStmt struct {
 	Value Expression
 }
 
+// AssignStmt assigns multiple values at once
+type AssignStmt struct {
+	L []LValue
+	R []Expression // A single value must be a call returning multiple values
+}
+
 type ExprStmt struct{ Expression }
 type Expression interface {
 	FormattableCode
@@ -79,6 +120,8 @@ type LValue interface {
 	Expression
 	GenPointer(c *Compiler) Operand
 	genPointer(c *Compiler) (Operand, Type)
+	// The type of the referenced value, without decaying arrays to pointers
+	storageType(c *Compiler) Type
 }
 
 type VarExpr string
@@ -90,6 +133,43 @@ type AccessExpr struct {
 	R string
 }
 
+// An instance of a generic function, resolved from the arguments of a call
+type InstanceExpr struct {
+	Name string // Name of the function, including its type arguments
+	Loc  Global
+	Ty   FuncType
+}
+
+// A generic function with explicit type arguments, such as `max[I32]`
+type GenericExpr struct {
+	Func Expression
+	Args []TypeExpr
+}
+
+// A method of a named type, resolved from an AccessExpr in a method call
+type MethodExpr struct {
+	Ty   NamedType
+	Name string
+}
+
+type IndexExpr struct {
+	V Expression // Array or pointer
+	I Expression
+}
+
+type SliceExpr struct {
+	V      Expression // Array, pointer or slice
+	Lo, Hi Expression // Optional
+}
+type LenExpr struct{ V Expression } // A call of the builtin len, resolved by CallExpr.builtin
+
+type SizeofExpr struct{ Ty TypeExpr }
+type AlignofExpr struct{ Ty TypeExpr }
+type OffsetofExpr struct {
+	Ty    TypeExpr
+	Field string
+}
+
 type AssignExpr struct {
 	L LValue
 	R Expression
@@ -110,6 +190,12 @@ type CastExpr struct {
 	Ty TypeExpr
 }
 
+// BitcastExpr reinterprets the bits of a value as another type of the same size
+type BitcastExpr struct {
+	V  Expression
+	Ty TypeExpr
+}
+
 type PrefixExpr struct {
 	Op PrefixOperator
 	V  Expression
@@ -128,6 +214,25 @@ type BooleanExpr struct {
 }
 type BooleanOperator int
 
+// An untyped initializer list, such as `{1, 2, 3}`
+type InitExpr []Expression
+type TupleExpr []Expression
+type KeyedExpr struct {
+	Name string
+	V    Expression
+}
+type CompositeExpr struct {
+	Ty   TypeExpr
+	Init InitExpr
+}
+
+// A function literal, compiled as a separate function and evaluated to its address
+type FuncExpr struct {
+	Param []VarDecl
+	Ret   TypeExpr
+	Body  []Statement
+}
+
 type IntegerExpr string
 type FloatExpr string
 type StringExpr string
@@ -140,15 +245,42 @@ type TypeExpr interface {
 
 type NamedTypeExpr string
 type NamespaceTypeExpr []string
-type PointerTypeExpr struct{ To TypeExpr }
+type GenericTypeExpr struct {
+	Name TypeExpr // NamedTypeExpr or NamespaceTypeExpr
+	Args []TypeExpr
+}
+type PointerTypeExpr struct {
+	To    TypeExpr
+	Const bool
+}
 type ArrayTypeExpr struct {
 	Ty TypeExpr
-	N  int
+	N  Expression
+}
+type SliceTypeExpr struct {
+	Ty    TypeExpr
+	Const bool
 }
+type TupleTypeExpr []TypeExpr
 type FuncTypeExpr struct {
 	Var   bool // true if the function uses C-style varags
 	Param []TypeExpr
 	Ret   TypeExpr
 }
+type EnumTypeExpr struct {
+	Ty      TypeExpr
+	Members []EnumMemberExpr
+}
+type EnumMemberExpr struct {
+	Name  string
+	Value Expression // Optional
+}
 type StructTypeExpr []VarDecl
 type UnionTypeExpr []VarDecl
+type VariantTypeExpr []VarDecl
+type InterfaceTypeExpr []MethodDecl
+type MethodDecl struct {
+	Name  string
+	Param []VarDecl
+	Ret   TypeExpr
+}
@@ -1,11 +1,39 @@
 package main
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
 func (p Program) GenProgram(c *Compiler) {
+	// Declare everything before generating any code, so toplevels can refer to each other in any order
+	for _, tl := range p {
+		tl.DeclareTypes(c)
+	}
+	for _, tl := range p {
+		tl.DeclareVars(c)
+	}
 	for _, tl := range p {
 		tl.GenToplevel(c)
 	}
 }
 
+func (ns NamespaceTL) DeclareTypes(c *Compiler) {
+	c.StartNamespace(ns.Name)
+	for _, tl := range ns.Body {
+		tl.DeclareTypes(c)
+	}
+	c.EndNamespace()
+}
+func (ns NamespaceTL) DeclareVars(c *Compiler) {
+	c.StartNamespace(ns.Name)
+	for _, tl := range ns.Body {
+		tl.DeclareVars(c)
+	}
+	c.EndNamespace()
+}
 func (ns NamespaceTL) GenToplevel(c *Compiler) {
 	c.StartNamespace(ns.Name)
 	for _, tl := range ns.Body {
@@ -14,23 +42,81 @@ func (ns NamespaceTL) GenToplevel(c *Compiler) {
 	c.EndNamespace()
 }
 
-func (f Function) GenToplevel(c *Compiler) {
+// params returns the parameters of the function, including the receiver of a method
+func (f Function) params() []VarDecl {
+	if f.Recv == nil {
+		return f.Param
+	}
+	return append([]VarDecl{*f.Recv}, f.Param...)
+}
+
+func (f Function) typ(c *Compiler) FuncType {
 	ty := FuncType{}
-	ty.Param = make([]ConcreteType, len(f.Param))
-	params := make([]IRParam, len(f.Param))
-	for i, param := range f.Param {
-		params[i].Name = param.Name
+	params := f.params()
+	ty.Param = make([]ConcreteType, len(params))
+	for i, param := range params {
 		ty.Param[i] = param.Ty.Get(c)
-		params[i].Ty = ty.Param[i]
 	}
-
-	var ret string
 	if f.Ret != nil {
-		ty.Ret = f.Ret.Get(c)
-		ret = ty.Ret.IRTypeName(c)
+		ty.Ret = resultType(c, f.Ret)
+	}
+	return ty
+}
+
+// recvType returns the name of the type a method is attached to
+func (f Function) recvType(c *Compiler) string {
+	ty := f.Recv.Ty
+	if ptr, ok := ty.(PointerTypeExpr); ok {
+		ty = ptr.To
+	}
+	name, ok := ty.(NamedTypeExpr)
+	if ok {
+		named, ok := c.Type(string(name)).(NamedType)
+		if ok && named.Name == c.NS().Name+string(name) {
+			return string(name)
+		}
 	}
-	c.StartFunction(f.Pub, f.Name, params, ret)
-	c.DeclareGlobal(true, f.Name, ty)
+	panic("Receiver of method " + f.Name + " must be a type defined in the same namespace, or a pointer to one")
+}
+
+func (_ Function) DeclareTypes(c *Compiler) {}
+func (f Function) DeclareVars(c *Compiler) {
+	if f.TypeParam != nil {
+		if f.Recv != nil {
+			panic("Method " + f.Name + " cannot have type parameters")
+		}
+		// Generic functions are compiled when they are instantiated
+		cur := c.NS()
+		if _, ok := cur.Vars[f.Name]; ok || cur.Consts[f.Name] != nil {
+			panic("Variable already exists")
+		}
+		cur.Vars[f.Name] = GenericFunc{f, append([]Namespace(nil), c.ns...)}
+		return
+	}
+	if f.Recv == nil {
+		c.DeclareGlobal(true, f.Name, f.typ(c))
+		return
+	}
+	// Methods live in a namespace named after their receiver type
+	c.StartNamespace(f.recvType(c))
+	c.DeclareGlobal(true, f.Name, f.typ(c))
+	c.EndNamespace()
+}
+func (f Function) GenToplevel(c *Compiler) {
+	if f.TypeParam != nil {
+		return
+	}
+	ty := f.typ(c)
+	params := make([]IRParam, len(ty.Param))
+	for i, param := range f.params() {
+		params[i] = IRParam{param.Name, ty.Param[i]}
+	}
+
+	name := f.Name
+	if f.Recv != nil {
+		name = f.recvType(c) + "." + name
+	}
+	c.StartFunction(f.Pub, name, params, ty.Ret)
 
 	for _, stmt := range f.Body {
 		stmt.GenStatement(c)
@@ -39,24 +125,532 @@ func (f Function) GenToplevel(c *Compiler) {
 	c.EndFunction()
 }
 
+// A thunk calls a method with a value receiver through a pointer to the receiver
+type thunk struct {
+	ty     NamedType
+	method string
+	fn     FuncType
+}
+
+func (t thunk) Global() Global {
+	return Global(t.ty.Name + "." + t.method + ".thunk")
+}
+
+func (t thunk) gen(c *Compiler) {
+	params := make([]IRParam, len(t.fn.Param))
+	args := make([]Expression, len(t.fn.Param))
+	params[0] = IRParam{"recv", PointerType{To: t.ty}}
+	args[0] = DerefExpr{VarExpr("recv")}
+	for i := 1; i < len(params); i++ {
+		name := "arg" + strconv.Itoa(i)
+		params[i] = IRParam{name, t.fn.Param[i]}
+		args[i] = VarExpr(name)
+	}
+
+	c.StartFunction(false, string(t.Global()), params, t.fn.Ret)
+	call := CallExpr{MethodExpr{t.ty, t.method}, args}
+	if t.fn.Ret == nil {
+		call.GenExpression(c)
+	} else {
+		ReturnStmt{call}.GenStatement(c)
+	}
+	c.EndFunction()
+}
+
 func (d VarsDecl) GenStatement(c *Compiler) {
-	ty := d.Ty.Get(c)
-	for _, name := range d.Names {
-		c.DeclareLocal(name, ty)
+	if d.Init == nil {
+		ty := d.Ty.Get(c)
+		for _, name := range d.Names {
+			c.DeclareLocal(name, ty)
+		}
+		return
+	}
+
+	types := d.types(c)
+	if len(d.Init) != len(d.Names) {
+		// Several variables initialized from the results of one call
+		ty := d.Init[0].TypeOf(c).(TupleType)
+		t := d.Init[0].GenExpression(c)
+		vals := make([]Operand, len(ty))
+		for i, fty := range ty {
+			typeCheck("initializer", fty, types[i])
+			vals[i] = genOffset(c, t, ty.repr().Offset(strconv.Itoa(i)))
+			if nty, ok := fty.Concrete().(NumericType); ok {
+				vals[i] = genPtrLoad(vals[i], nty, c)
+			}
+		}
+		for i, name := range d.Names {
+			genPtrStore(c.DefineLocal(name, types[i]), genConvert(c, vals[i], ty[i], types[i]), types[i], c)
+		}
+		return
+	}
+	for i, name := range d.Names {
+		if init, ok := d.Init[i].(InitExpr); ok {
+			// Evaluate the initializer before the variable comes into scope
+			loc := c.Temporary()
+			c.allocLocal(loc, types[i])
+			init.genInit(c, loc, types[i])
+			c.bindLocal(name, loc, types[i])
+		} else {
+			// Evaluate the value before the variable comes into scope
+			v := genValue(c, d.Init[i], types[i])
+			genPtrStore(c.DefineLocal(name, types[i]), v, types[i], c)
+		}
+	}
+}
+func (_ VarsDecl) DeclareTypes(c *Compiler) {}
+func (d VarsDecl) DeclareVars(c *Compiler) {
+	if d.Extern && d.Init != nil {
+		panic("Cannot initialize extern variable")
+	}
+	for i, ty := range d.types(c) {
+		c.DeclareGlobal(d.Extern, d.Names[i], ty)
 	}
 }
 func (d VarsDecl) GenToplevel(c *Compiler) {
+	if d.Init == nil {
+		return
+	}
+	if len(d.Init) != len(d.Names) {
+		panic("Initializer is not constant: " + d.Init[0].Format(0))
+	}
+	for i, ty := range d.types(c) {
+		c.DefineGlobal(d.Names[i], genData(c, ty, d.Init[i]))
+	}
+}
+
+// types returns the type of each declared variable, inferring it from the initializer if necessary
+func (d VarsDecl) types(c *Compiler) []ConcreteType {
+	var tuple TupleType
+	if len(d.Init) == 1 && len(d.Names) > 1 {
+		// Several variables may be initialized from the results of one call
+		if _, ok := d.Init[0].(InitExpr); !ok {
+			tuple, _ = d.Init[0].TypeOf(c).(TupleType)
+		}
+		if tuple != nil && len(tuple) != len(d.Names) {
+			panic(fmt.Sprintf("Assignment mismatch: %d variables but %d values", len(d.Names), len(tuple)))
+		}
+	}
+	if d.Init != nil && tuple == nil && len(d.Init) != len(d.Names) {
+		panic("Wrong number of initializers in variable declaration")
+	}
+
+	types := make([]ConcreteType, len(d.Names))
+	for i := range d.Names {
+		if d.Ty != nil {
+			types[i] = d.Ty.Get(c)
+		} else if tuple != nil {
+			types[i] = tuple[i]
+		} else if _, ok := d.Init[i].TypeOf(c).(TupleType); ok {
+			panic("Multiple values used in single-value context: " + d.Init[i].Format(0))
+		} else if ty, ok := d.Init[i].TypeOf(c).(ConcreteType); ok {
+			// Keep the name of named types
+			types[i] = ty
+		} else {
+			types[i] = d.Init[i].TypeOf(c).Concrete()
+		}
+	}
+	return types
+}
+
+// genValue generates the value of e, converted for storage in a location of type ty
+func genValue(c *Compiler, e Expression, ty ConcreteType) Operand {
+	typeCheck("initializer", valueType(c, e, ty), ty)
+	checkRange(c, e, ty)
+	return genConvert(c, e.GenExpression(c), e.TypeOf(c), ty)
+}
+
+func (e InitExpr) genInit(c *Compiler, ptr Operand, ty ConcreteType) {
+	e = e.positional(ty)
+	named := ty // For error messages, since the switch below loses the name of named types
+	init := func(off int, ty ConcreteType, i int) {
+		loc := genOffset(c, ptr, off)
+		if i >= len(e) || e[i] == nil {
+			ty.GenZero(c, loc)
+		} else if init, ok := e[i].(InitExpr); ok {
+			init.genInit(c, loc, ty)
+		} else {
+			genPtrStore(loc, genValue(c, e[i], ty), ty, c)
+		}
+	}
+
+	switch ty := ty.Concrete().(type) {
+	case StructType:
+		if len(e) > len(ty.compositeType) {
+			panic("Too many values in initializer for " + ty.Format(0))
+		}
+		off := 0
+		for i, field := range ty.compositeType {
+			m := field.Ty.Metrics()
+			off = -(-off & -m.Align) // Align upwards
+			init(off, field.Ty, i)
+			off += m.Size
+		}
+
+	case TupleType:
+		e.genInit(c, ptr, ty.repr())
+
+	case VariantType:
+		if len(e) == 0 {
+			ty.GenZero(c, ptr)
+			break
+		}
+		// The named member becomes the active member
+		name, _ := e.variantMember(named)
+		genPtrStore(ptr, IRInt(ty.Tag(name)), TypeU32, c)
+		UnionType{ty.compositeType}.GenZero(c, genOffset(c, ptr, ty.Offset(name)))
+		e = InitExpr{e[0].(KeyedExpr).V}
+		init(ty.Offset(name), ty.Field(name), 0)
+
+	case UnionType:
+		if len(e) > 1 {
+			panic("Too many values in initializer for " + ty.Format(0))
+		} else if len(e) == 0 {
+			ty.GenZero(c, ptr)
+		} else {
+			if ty.compositeType[0].Ty.Metrics().Size < ty.Metrics().Size {
+				// Zero the bytes not covered by the initialized member
+				ty.GenZero(c, ptr)
+			}
+			init(0, ty.compositeType[0].Ty, 0)
+		}
+
+	case ArrayType:
+		if len(e) > ty.N {
+			panic("Too many values in initializer for " + ty.Format(0))
+		}
+		for i := 0; i < ty.N; i++ {
+			init(i*ty.Ty.Metrics().Size, ty.Ty, i)
+		}
+
+	default:
+		panic("Initializer list used for non-aggregate type " + ty.Format(0))
+	}
+}
+
+// positional returns the values of e in field order, resolving any field names
+// Fields named by neither a name nor a position are nil
+func (e InitExpr) positional(ty ConcreteType) InitExpr {
+	keyed := false
+	for _, v := range e {
+		if _, ok := v.(KeyedExpr); ok {
+			keyed = true
+		}
+	}
+	if !keyed {
+		return e
+	}
+	if _, ok := ty.Concrete().(VariantType); ok {
+		// Variant initializers name their member, which is resolved by variantMember
+		return e
+	}
+
+	st, ok := ty.Concrete().(StructType)
+	if !ok {
+		panic("Field names used in initializer for non-struct type " + ty.Format(0))
+	}
+	vals := make(InitExpr, len(st.compositeType))
+	i := 0
+	for _, v := range e {
+		if k, ok := v.(KeyedExpr); ok {
+			for i = 0; i < len(st.compositeType) && st.compositeType[i].Name != k.Name; i++ {
+			}
+			if i == len(st.compositeType) {
+				panic("No such field: " + k.Name)
+			}
+			v = k.V
+		}
+		if i >= len(vals) {
+			panic("Too many values in initializer for " + ty.Format(0))
+		}
+		if vals[i] != nil {
+			panic("Duplicate field " + st.compositeType[i].Name + " in initializer")
+		}
+		vals[i] = v
+		i++
+	}
+	return vals
+}
+
+// variantMember returns the name and value of the member named by an initializer for a variant of type ty
+func (e InitExpr) variantMember(ty ConcreteType) (string, Expression) {
+	k, ok := e[0].(KeyedExpr)
+	if len(e) != 1 || !ok {
+		panic("Initializer for " + ty.Format(0) + " must name exactly one member")
+	}
+	if ty.Concrete().(VariantType).Tag(k.Name) < 0 {
+		panic("No such field: " + k.Name)
+	}
+	return k.Name, k.V
+}
+
+// genData generates the data items for a global of type ty initialized to e
+func genData(c *Compiler, ty ConcreteType, e Expression) []IRDataItem {
+	if init, ok := e.(InitExpr); ok {
+		return init.genData(c, ty)
+	}
+	if comp, ok := e.(CompositeExpr); ok {
+		typeCheck("initializer", comp.TypeOf(c), ty)
+		return comp.Init.genData(c, ty)
+	}
+	if _, ok := ty.Concrete().(InterfaceType); ok {
+		typeCheck("initializer", e.TypeOf(c), ty)
+		if addr, ok := staticAddress(c, e); ok {
+			vtab := c.vtable(pointee(e.TypeOf(c)), ty)
+			return []IRDataItem{{"l", addr}, {"l", vtab.Operand()}}
+		}
+		panic("Initializer is not constant: " + e.Format(0))
+	}
+	if _, ok := ty.Concrete().(NumericType); !ok {
+		panic("Initializer for " + ty.Format(0) + " must be an initializer list")
+	}
+
+	typeCheck("initializer", valueType(c, e, ty), ty)
+	if k, ok := constValue(c, e); ok {
+		k.checkRange(ty)
+		return []IRDataItem{{ty.IRTypeName(c), k.Convert(ty).Operand()}}
+	}
+	if addr, ok := staticAddress(c, e); ok {
+		return []IRDataItem{{"l", addr}}
+	}
+	panic("Initializer is not constant: " + e.Format(0))
+}
+
+func (e InitExpr) genData(c *Compiler, ty ConcreteType) (items []IRDataItem) {
+	e = e.positional(ty)
+	named := ty // For error messages, since the switch below loses the name of named types
+	off := 0
+	item := func(at int, ty ConcreteType, v Expression) {
+		if v == nil {
+			return
+		}
+		if at > off {
+			items = append(items, IRDataItem{"z", strconv.Itoa(at - off)})
+		}
+		items = append(items, genData(c, ty, v)...)
+		off = at + ty.Metrics().Size
+	}
+
+	switch ty := ty.Concrete().(type) {
+	case StructType:
+		if len(e) > len(ty.compositeType) {
+			panic("Too many values in initializer for " + ty.Format(0))
+		}
+		at := 0
+		for i, v := range e {
+			fty := ty.compositeType[i].Ty
+			at = -(-at & -fty.Metrics().Align) // Align upwards
+			item(at, fty, v)
+			at += fty.Metrics().Size
+		}
+
+	case UnionType:
+		if len(e) > 1 {
+			panic("Too many values in initializer for " + ty.Format(0))
+		}
+		for _, v := range e {
+			item(0, ty.compositeType[0].Ty, v)
+		}
+
+	case VariantType:
+		if len(e) > 0 {
+			name, v := e.variantMember(named)
+			item(0, TypeU32, IntegerExpr(strconv.Itoa(ty.Tag(name))))
+			item(ty.Offset(name), ty.Field(name), v)
+		}
+
+	case ArrayType:
+		if len(e) > ty.N {
+			panic("Too many values in initializer for " + ty.Format(0))
+		}
+		for i, v := range e {
+			item(i*ty.Ty.Metrics().Size, ty.Ty, v)
+		}
+
+	default:
+		panic("Initializer list used for non-aggregate type " + ty.Format(0))
+	}
+
+	if size := ty.Metrics().Size; size > off {
+		items = append(items, IRDataItem{"z", strconv.Itoa(size - off)})
+	}
+	return
+}
+
+// staticAddress returns the value of a pointer expression whose address is known at compile time
+func staticAddress(c *Compiler, e Expression) (string, bool) {
+	var loc Global
+	var off int
+	var ok bool
+	switch e := e.(type) {
+	case StringExpr:
+		return c.String(string(e)).Operand(), true
+	case FuncExpr:
+		return e.hoist(c).Operand(), true
+	case RefExpr:
+		loc, off, ok = staticLocation(c, e.V)
+	case LValue:
+		// Arrays decay to a pointer to their first element
+		if _, isArr := e.storageType(c).Concrete().(ArrayType); isArr {
+			loc, off, ok = staticLocation(c, e)
+		}
+	}
+
+	if !ok {
+		return "", false
+	} else if off == 0 {
+		return loc.Operand(), true
+	} else {
+		return loc.Operand() + " + " + strconv.Itoa(off), true
+	}
+}
+
+// staticLocation returns the location of a global lvalue
+func staticLocation(c *Compiler, e LValue) (loc Global, off int, ok bool) {
+	switch e := e.(type) {
+	case VarExpr:
+		loc, ok = c.Variable(string(e)).Loc.(Global)
+		return
+	case AccessExpr:
+		lty := e.L.TypeOf(c)
+		if ns, isNs := lty.(Namespace); isNs {
+			return Global(ns.Name + e.R), 0, true
+		}
+		comp, isComp := lty.Concrete().(CompositeType)
+		if !isComp {
+			// Accesses through a pointer depend on its value at runtime
+			return
+		}
+		if comp.Field(e.R) == nil {
+			panic("No such field: " + e.R)
+		}
+		loc, off, ok = staticLocation(c, e.L)
+		off += comp.Offset(e.R)
+		return
+	case IndexExpr:
+		a, isArr := undecayedType(c, e.V).Concrete().(ArrayType)
+		lv, isLv := e.V.(LValue)
+		if !isArr || !isLv {
+			// Indexes into pointers and slices depend on their value at runtime
+			return
+		}
+		checkIndex(c, e.I)
+		i, isConst := constIndex(c, a, e.I)
+		if !isConst {
+			return
+		}
+		loc, off, ok = staticLocation(c, lv)
+		off += i * a.Ty.Metrics().Size
+		return
+	}
+	return
+}
+
+func (d ConstDecl) DeclareTypes(c *Compiler) {
+	c.DeclareConst(d.Name, func() Constant { return d.value(c) })
+}
+func (_ ConstDecl) DeclareVars(c *Compiler) {}
+func (d ConstDecl) GenToplevel(c *Compiler) {
+	c.Variable(d.Name) // Make sure the value is evaluated, even if it is unused
+}
+func (d ConstDecl) GenStatement(c *Compiler) {
+	c.DeclareConst(d.Name, func() Constant { return d.value(c) })
+}
+
+// value evaluates the value of the constant, converted to its type if it has one
+func (d ConstDecl) value(c *Compiler) Constant {
+	k, ok := constValue(c, d.Value)
+	if !ok {
+		panic("Value of constant " + d.Name + " is not constant: " + d.Value.Format(0))
+	}
+	if d.Ty == nil {
+		return k
+	}
+
 	ty := d.Ty.Get(c)
-	for _, name := range d.Names {
-		c.DeclareGlobal(d.Extern, name, ty)
+	if _, ok := ty.Concrete().(NumericType); !ok {
+		panic("Constant " + d.Name + " must have a numeric type")
 	}
+	typeCheck("constant declaration", k.Ty, ty)
+	k.checkRange(ty)
+	return k.Convert(ty)
 }
 
+func (t TypeDef) DeclareTypes(c *Compiler) {
+	if t.TypeParam != nil {
+		if _, ok := t.Ty.(EnumTypeExpr); ok {
+			panic("Enum type " + t.Name + " cannot have type parameters")
+		}
+		c.DeclareGenericType(t)
+		return
+	}
+	named := NamedType{c.NS().Name + t.Name, new(ConcreteType)}
+	enum, isEnum := t.Ty.(EnumTypeExpr)
+	slot := c.DeclareType(t.Name, func(*ConcreteType) {
+		if isEnum {
+			*named.ty = enum.get(c, named.Name)
+		} else {
+			*named.ty = t.Ty.Get(c)
+		}
+		if named.embeddedIn(*named.ty) {
+			panic("Type " + named.Name + " contains itself")
+		}
+	})
+	*slot = named
+
+	if isEnum {
+		// Declare the members as constants in a namespace named after the type
+		c.StartNamespace(t.Name)
+		for i, m := range enum.Members {
+			if c.NS().Consts[m.Name] != nil {
+				panic("Duplicate enum member " + m.Name)
+			}
+			i := i
+			c.DeclareConst(m.Name, func() Constant {
+				ty := c.resolveType(t.Name, slot)
+				return Constant{Ty: ty, Int: ty.Concrete().(EnumType).Members[i].Value}
+			})
+		}
+		c.EndNamespace()
+	}
+}
+func (t TypeAlias) DeclareTypes(c *Compiler) {
+	c.DeclareType(t.Name, func(slot *ConcreteType) {
+		*slot = t.Ty.Get(c)
+	})
+}
+func (_ TypeDef) DeclareVars(c *Compiler)   {}
+func (_ TypeAlias) DeclareVars(c *Compiler) {}
 func (t TypeDef) GenToplevel(c *Compiler) {
-	*c.AliasType(t.Name) = NamedType{t.Ty.Get(c), c.NS().Name + t.Name}
+	if t.TypeParam == nil {
+		c.Type(t.Name) // Make sure the definition is compiled, even if it is unused
+	}
 }
 func (t TypeAlias) GenToplevel(c *Compiler) {
-	*c.AliasType(t.Name) = t.Ty.Get(c)
+	c.Type(t.Name)
+}
+
+// genBool generates the truth value of e as a Bool
+func genBool(c *Compiler, what string, e Expression) Operand {
+	ty := checkCond(c, what, e)
+	v := e.GenExpression(c)
+	if ty.Equals(TypeBool) {
+		return v
+	}
+	t := c.Temporary()
+	c.Insn(t, TypeBool.IRBaseTypeName(), BinCne.Instruction(ty), v, IRInt(0))
+	return t
+}
+
+// genCond generates a value for jnz that is nonzero if e is true
+func genCond(c *Compiler, e Expression) Operand {
+	ty := checkCond(c, "Condition", e)
+	k, isConst := constValue(c, e)
+	if ty.IRBaseTypeName() == 'w' || isConst && k.Int == int64(int32(k.Int)) {
+		return e.GenExpression(c)
+	}
+	// jnz only looks at the low 32 bits of its argument
+	return genBool(c, "Condition", e)
 }
 
 func (i IfStmt) GenStatement(c *Compiler) {
@@ -64,20 +658,16 @@ func (i IfStmt) GenStatement(c *Compiler) {
 	elseB := c.Block()
 	endB := c.Block()
 
-	cond := i.Cond.GenExpression(c)
+	cond := genCond(c, i.Cond)
 	c.Insn(0, 0, "jnz", cond, thenB, elseB)
 
 	c.StartBlock(thenB)
-	for _, stmt := range i.Then {
-		stmt.GenStatement(c)
-	}
+	genBlock(c, i.Then)
 	if !c.ret { // HACK: we shouldn't really access this private field
 		c.Insn(0, 0, "jmp", endB)
 	}
 	c.StartBlock(elseB)
-	for _, stmt := range i.Else {
-		stmt.GenStatement(c)
-	}
+	genBlock(c, i.Else)
 	c.StartBlock(endB)
 }
 
@@ -87,22 +677,20 @@ func (f ForStmt) GenStatement(c *Compiler) {
 	endB := c.Block()
 
 	c.StartLoop(startB, endB)
+	c.StartScope()
 
-	// TODO: scope
 	if f.Init != nil {
 		f.Init.GenStatement(c)
 	}
 
 	c.StartBlock(startB)
 	if f.Cond != nil {
-		cond := f.Cond.GenExpression(c)
+		cond := genCond(c, f.Cond)
 		c.Insn(0, 0, "jnz", cond, bodyB, endB)
 	}
 
 	c.StartBlock(bodyB)
-	for _, stmt := range f.Body {
-		stmt.GenStatement(c)
-	}
+	genBlock(c, f.Body)
 
 	if f.Step != nil {
 		f.Step.GenExpression(c)
@@ -110,9 +698,216 @@ func (f ForStmt) GenStatement(c *Compiler) {
 	c.Insn(0, 0, "jmp", startB)
 	c.StartBlock(endB)
 
+	c.EndScope()
 	c.EndLoop()
 }
 
+func (s SwitchStmt) GenStatement(c *Compiler) {
+	ty := s.Value.TypeOf(c).Concrete()
+	_, isNum := ty.(NumericType)
+	_, isPtr := ty.(PointerType)
+	if !isNum || isPtr || isFloat(ty) || ty.Equals(TypeBool) {
+		panic("Switch value must be an integer or enum, not " + s.Value.TypeOf(c).Format(0))
+	}
+
+	caseBs := make([]Block, len(s.Cases))
+	for i := range s.Cases {
+		caseBs[i] = c.Block()
+	}
+	endB := c.Block()
+	defB := endB
+
+	// Collect the case values
+	var vals []switchValue
+	for i, sc := range s.Cases {
+		if sc.Values == nil {
+			defB = caseBs[i]
+		}
+		for _, e := range sc.Values {
+			k, ok := constValue(c, e)
+			if !ok {
+				panic("Case value is not constant: " + e.Format(0))
+			}
+			typeCheck("switch case", k.Ty, s.Value.TypeOf(c))
+			k.checkRange(ty)
+			k = k.Convert(ty)
+			for _, v := range vals {
+				if v.V == k.Int {
+					panic("Duplicate case " + e.Format(0) + " in switch")
+				}
+			}
+			vals = append(vals, switchValue{k.Int, caseBs[i]})
+		}
+	}
+
+	if enum, ok := ty.(EnumType); ok && defB == endB {
+		// Every member must be handled if there's no default case
+		var missing []string
+	members:
+		for _, m := range enum.Members {
+			for _, v := range vals {
+				if v.V == m.Value {
+					continue members
+				}
+			}
+			missing = append(missing, m.Name)
+		}
+		if missing != nil {
+			panic("Switch on " + enum.Name + " is missing cases: " + strings.Join(missing, ", "))
+		}
+	}
+
+	signed := ty.(NumericType).Signed()
+	sort.Slice(vals, func(i, j int) bool {
+		if signed {
+			return vals[i].V < vals[j].V
+		}
+		return uint64(vals[i].V) < uint64(vals[j].V)
+	})
+
+	v := s.Value.GenExpression(c)
+	genSwitchTree(c, v, ty.IRBaseTypeName(), signed, vals, defB)
+
+	for i, sc := range s.Cases {
+		c.StartBlock(caseBs[i])
+		genBlock(c, sc.Body)
+		if !c.ret {
+			c.Insn(0, 0, "jmp", endB)
+		}
+	}
+	c.StartBlock(endB)
+}
+
+type switchValue struct {
+	V   int64
+	Blk Block
+}
+
+// genSwitchTree generates a binary search over the sorted case values of a switch statement
+func genSwitchTree(c *Compiler, v Operand, base byte, signed bool, vals []switchValue, defB Block) {
+	if len(vals) > 3 {
+		mid := len(vals) / 2
+		loB := c.Block()
+		hiB := c.Block()
+		cmp := "cult"
+		if signed {
+			cmp = "cslt"
+		}
+
+		t := c.Temporary()
+		c.Insn(t, 'w', cmp+string(base), v, IRInteger(strconv.FormatInt(vals[mid].V, 10)))
+		c.Insn(0, 0, "jnz", t, loB, hiB)
+		c.StartBlock(loB)
+		genSwitchTree(c, v, base, signed, vals[:mid], defB)
+		c.StartBlock(hiB)
+		genSwitchTree(c, v, base, signed, vals[mid:], defB)
+		return
+	}
+
+	// Few enough values to test them one at a time
+	for i, sv := range vals {
+		nextB := defB
+		if i < len(vals)-1 {
+			nextB = c.Block()
+		}
+		t := c.Temporary()
+		c.Insn(t, 'w', "ceq"+string(base), v, IRInteger(strconv.FormatInt(sv.V, 10)))
+		c.Insn(0, 0, "jnz", t, sv.Blk, nextB)
+		if i < len(vals)-1 {
+			c.StartBlock(nextB)
+		}
+	}
+	if len(vals) == 0 {
+		c.Insn(0, 0, "jmp", defB)
+	}
+}
+
+func (s MatchStmt) GenStatement(c *Compiler) {
+	v, ok := s.Value.TypeOf(c).Concrete().(VariantType)
+	if !ok {
+		panic("Match value must be a variant, not " + s.Value.TypeOf(c).Format(0))
+	}
+	name := s.Value.TypeOf(c).Format(0)
+
+	caseBs := make([]Block, len(s.Cases))
+	for i := range s.Cases {
+		caseBs[i] = c.Block()
+	}
+	endB := c.Block()
+	defB := endB
+
+	var vals []switchValue
+	for i, mc := range s.Cases {
+		if mc.Variant == "" {
+			defB = caseBs[i]
+			continue
+		}
+		tag := v.Tag(mc.Variant)
+		if tag < 0 {
+			panic(name + " has no variant " + mc.Variant)
+		}
+		for _, sv := range vals {
+			if sv.V == int64(tag) {
+				panic("Duplicate case " + mc.Variant + " in match")
+			}
+		}
+		vals = append(vals, switchValue{int64(tag), caseBs[i]})
+	}
+
+	if defB == endB {
+		// Every member must be handled if there's no default case
+		var missing []string
+		for tag, field := range v.compositeType {
+			found := false
+			for _, sv := range vals {
+				found = found || sv.V == int64(tag)
+			}
+			if !found {
+				missing = append(missing, field.Name)
+			}
+		}
+		if missing != nil {
+			panic("Match on " + name + " is missing cases: " + strings.Join(missing, ", "))
+		}
+	}
+	sort.Slice(vals, func(i, j int) bool {
+		return vals[i].V < vals[j].V
+	})
+
+	ptr := s.Value.GenExpression(c)
+	tag := genPtrLoad(ptr, TypeU32, c)
+	payload := genOffset(c, ptr, v.Offset(""))
+	genSwitchTree(c, tag, 'w', false, vals, defB)
+
+	for i, mc := range s.Cases {
+		c.StartBlock(caseBs[i])
+		c.StartScope()
+		if mc.Bind != "" {
+			// The binding refers to the payload in place, so it is only valid while the member is active
+			c.Scope()[mc.Bind] = Variable{payload, v.Field(mc.Variant)}
+		}
+		genBlock(c, mc.Body)
+		c.EndScope()
+		if !c.ret {
+			c.Insn(0, 0, "jmp", endB)
+		}
+	}
+	c.StartBlock(endB)
+}
+
+func (b BlockStmt) GenStatement(c *Compiler) {
+	genBlock(c, b)
+}
+
+// genBlock generates a list of statements in a new scope
+func genBlock(c *Compiler, body []Statement) {
+	c.StartScope()
+	for _, stmt := range body {
+		stmt.GenStatement(c)
+	}
+	c.EndScope()
+}
+
 func (_ BreakStmt) GenStatement(c *Compiler) {
 	c.Insn(0, 0, "jmp", c.Loop().End)
 	c.StartBlock(c.Block())
@@ -123,14 +918,86 @@ func (_ ContinueStmt) GenStatement(c *Compiler) {
 }
 
 func (r ReturnStmt) GenStatement(c *Compiler) {
-	if r.Value != nil {
+	if vals, ok := r.Value.(TupleExpr); ok {
+		ty, ok := c.ReturnType().(TupleType)
+		if !ok || len(ty) != len(vals) {
+			panic(fmt.Sprintf("Wrong number of return values: expected %d, got %d", valueCount(c.ReturnType()), len(vals)))
+		}
+		t := c.Temporary()
+		c.allocLocal(t, ty)
+		InitExpr(vals).genInit(c, t, ty)
+		c.Insn(0, 0, "ret", t)
+	} else if r.Value != nil {
+		ty := c.ReturnType()
+		if ty == nil {
+			panic("Return value in function with no return type: " + r.Value.Format(0))
+		}
+		typeCheck("return", valueType(c, r.Value, ty), ty)
+		checkRange(c, r.Value, ty)
 		v := r.Value.GenExpression(c)
-		c.Insn(0, 0, "ret", v)
+		c.Insn(0, 0, "ret", genConvert(c, v, r.Value.TypeOf(c), ty))
 	} else {
+		if c.ReturnType() != nil {
+			panic("Missing return value in function returning " + c.ReturnType().Format(0))
+		}
 		c.Insn(0, 0, "ret")
 	}
 }
 
+func (s AssignStmt) GenStatement(c *Compiler) {
+	vals := make([]Operand, len(s.L))
+	types := make([]Type, len(s.L))
+	if len(s.R) == 1 {
+		ty, ok := s.R[0].TypeOf(c).(TupleType)
+		if !ok || len(ty) != len(s.L) {
+			panic(fmt.Sprintf("Assignment mismatch: %d variables but %d values", len(s.L), valueCount(s.R[0].TypeOf(c))))
+		}
+		t := s.R[0].GenExpression(c)
+		for i, fty := range ty {
+			vals[i] = genOffset(c, t, ty.repr().Offset(strconv.Itoa(i)))
+			if nty, ok := fty.Concrete().(NumericType); ok {
+				vals[i] = genPtrLoad(vals[i], nty, c)
+			}
+			types[i] = fty
+		}
+	} else if len(s.R) != len(s.L) {
+		panic(fmt.Sprintf("Assignment mismatch: %d variables but %d values", len(s.L), len(s.R)))
+	} else {
+		// Evaluate every value before assigning any of them
+		for i, e := range s.R {
+			vals[i] = e.GenExpression(c)
+			types[i] = e.TypeOf(c)
+			if _, ok := types[i].Concrete().(NumericType); !ok {
+				// Aggregates are represented by their address, so copy them in case they are assigned to
+				ty := types[i].Concrete()
+				t := c.Temporary()
+				c.allocLocal(t, ty)
+				ty.GenCopy(c, t, vals[i])
+				vals[i] = t
+			}
+		}
+	}
+
+	for i, l := range s.L {
+		if name, ok := l.(VarExpr); ok && name == "_" {
+			continue
+		}
+		if _, ok := constValue(c, l); ok {
+			panic("Cannot assign to constant " + l.Format(0))
+		}
+		if readOnly(c, l) {
+			panic("Cannot assign through const pointer: " + l.Format(0))
+		}
+		ty := l.storageType(c).Concrete()
+		typeCheck("assignment", types[i], ty)
+		if len(s.R) == len(s.L) {
+			checkRange(c, s.R[i], ty)
+		}
+		ptr, _ := l.genPointer(c)
+		genPtrStore(ptr, genConvert(c, vals[i], types[i], ty), ty, c)
+	}
+}
+
 func (e ExprStmt) GenStatement(c *Compiler) {
 	if e.TypeOf(c) != nil {
 		panic("Expression returning non-void cannot be used as statement")
@@ -141,20 +1008,19 @@ func (e ExprStmt) GenStatement(c *Compiler) {
 func (e AccessExpr) genPointer(c *Compiler) (Operand, Type) {
 	lty := e.L.TypeOf(c)
 	if ns, ok := lty.(Namespace); ok {
-		return Global(ns.Name + e.R), ns.Vars[e.R]
+		v := c.nsMember(ns, e.R)
+		if _, ok := v.Loc.(Constant); ok {
+			panic("Cannot take the address of constant " + e.Format(0))
+		}
+		return v.Loc, v.Ty
 	}
 
-	l := e.L.GenPointer(c)
-	for {
-		if p, ok := lty.Concrete().(PointerType); ok {
-			lty = p.To
-			l = genPtrLoad(l, PointerType{}, c)
-		} else {
-			break
-		}
+	if _, ok := e.variant(c); ok {
+		panic("Variant member " + e.Format(0) + " can only be read in a match statement")
 	}
+	l, lty := e.base(c)
 
-	comp := lty.Concrete().(CompositeType)
+	comp := lty.(CompositeType)
 	fty := comp.Field(e.R)
 	if off := comp.Offset(e.R); off > 0 {
 		t := c.Temporary()
@@ -164,10 +1030,28 @@ func (e AccessExpr) genPointer(c *Compiler) (Operand, Type) {
 		return l, fty
 	}
 }
+
+// base returns a pointer to the composite value being accessed, dereferencing pointers as required
+func (e AccessExpr) base(c *Compiler) (Operand, ConcreteType) {
+	lty := e.L.TypeOf(c)
+	l := e.L.GenPointer(c)
+	for {
+		if p, ok := lty.Concrete().(PointerType); ok {
+			lty = p.To
+			l = genPtrLoad(l, PointerType{}, c)
+		} else {
+			break
+		}
+	}
+	return l, lty.Concrete()
+}
 func (e AccessExpr) GenPointer(c *Compiler) Operand {
 	return genLValuePtr(e, c)
 }
 func (e AccessExpr) GenExpression(c *Compiler) Operand {
+	if k, ok := e.Const(c); ok {
+		return k.IR()
+	}
 	return genLValueExpr(e, c)
 }
 
@@ -176,10 +1060,21 @@ func (e AssignExpr) GenExpression(c *Compiler) Operand {
 		return e.R.GenExpression(c)
 	}
 
-	// TODO: allow storing non-numeric types
-	ty := e.typeOf(c).Concrete().(NumericType)
-	l := e.L.GenPointer(c)
-	r := e.R.GenExpression(c)
+	ty := e.typeOf(c).Concrete()
+	if acc, ok := e.L.(AccessExpr); ok {
+		if v, ok := acc.variant(c); ok {
+			// Assigning to a variant member makes it the active member
+			l, _ := acc.base(c)
+			genPtrStore(l, IRInt(v.Tag(acc.R)), TypeU32, c)
+			l = genOffset(c, l, v.Offset(acc.R))
+			r := genConvert(c, e.R.GenExpression(c), e.R.TypeOf(c), ty)
+			genPtrStore(l, r, ty, c)
+			return l
+		}
+	}
+
+	l, _ := e.L.genPointer(c)
+	r := genConvert(c, e.R.GenExpression(c), e.R.TypeOf(c), ty)
 	genPtrStore(l, r, ty, c)
 	return l
 }
@@ -196,6 +1091,23 @@ func (e MutateExpr) GenExpression(c *Compiler) Operand {
 }
 
 func (e CallExpr) GenExpression(c *Compiler) Operand {
+	if b, ok := e.builtin(c); ok {
+		return b.GenExpression(c)
+	}
+	if v, i, t, ok := e.dynamic(c); ok {
+		// Look up the method in the vtable and pass it the data pointer
+		ptr := v.GenExpression(c)
+		data := genPtrLoad(ptr, PointerType{}, c)
+		vtab := genPtrLoad(genOffset(c, ptr, 8), PointerType{}, c)
+		f := genPtrLoad(genOffset(c, vtab, 8*i), PointerType{}, c)
+		return genCall(c, f, t, []TypedOperand{{"l", data}}, e.Args)
+	}
+	if m, ok := e.method(c); ok {
+		return m.GenExpression(c)
+	}
+	if g, ok := e.generic(c); ok {
+		return g.GenExpression(c)
+	}
 	t, ptr := e.typeOf(c)
 	var f Operand
 	if ptr {
@@ -203,17 +1115,26 @@ func (e CallExpr) GenExpression(c *Compiler) Operand {
 	} else {
 		f = e.Func.(LValue).GenPointer(c)
 	}
+	return genCall(c, f, t, nil, e.Args)
+}
 
-	call := CallOperand{t.Var, f, make([]TypedOperand, len(e.Args))}
-	for i, arg := range e.Args {
-		var ty Type
+// genCall generates a call to f, of type t
+// The operands in pre are passed before the arguments
+func genCall(c *Compiler, f Operand, t FuncType, pre []TypedOperand, args []Expression) Operand {
+	call := CallOperand{t.Var, f, pre}
+	for i, arg := range args {
+		var ty ConcreteType
 		if i < len(t.Param) {
 			ty = t.Param[i]
 		} else {
-			ty = arg.TypeOf(c)
+			ty = arg.TypeOf(c).Concrete()
+			if isFloat(ty) {
+				// C promotes variadic floats to double
+				ty = TypeF64
+			}
 		}
-		call.Args[i].Ty = ty.Concrete().IRTypeName(c)
-		call.Args[i].Op = arg.GenExpression(c)
+		op := genConvert(c, arg.GenExpression(c), arg.TypeOf(c), ty)
+		call.Args = append(call.Args, TypedOperand{ty.IRTypeName(c), op})
 	}
 
 	if t.Ret == nil {
@@ -221,37 +1142,156 @@ func (e CallExpr) GenExpression(c *Compiler) Operand {
 		return nil
 	} else {
 		v := c.Temporary()
-		c.Insn(v, t.Ret.IRBaseTypeName(), "call", call)
+		if ty := t.Ret.IRBaseTypeName(); ty != 0 {
+			c.Insn(v, ty, "call", call)
+			if n, ok := t.Ret.Concrete().(NumericType); ok {
+				// The C ABI leaves the upper bits of narrow return values undefined
+				return truncate(c, v, n)
+			}
+		} else {
+			// Aggregates are returned as a pointer to a copy owned by the caller
+			c.AggregateInsn(v, t.Ret.IRTypeName(c), "call", call)
+		}
 		return v
 	}
 }
 
 func (e CastExpr) GenExpression(c *Compiler) Operand {
+	if k, ok := e.Const(c); ok {
+		return k.IR()
+	}
+	ty := e.TypeOf(c).Concrete()
+	return genConvert(c, e.V.GenExpression(c), castSource(c, e.V), ty)
+}
+
+func (e BitcastExpr) GenExpression(c *Compiler) Operand {
+	if k, ok := e.Const(c); ok {
+		return k.IR()
+	}
 	ty := e.TypeOf(c).Concrete().(NumericType)
-	vty := e.V.TypeOf(c).Concrete().(NumericType)
+	vty := castSource(c, e.V).Concrete().(NumericType)
 
 	v := e.V.GenExpression(c)
-	// TODO: floating point types
-	if vty.Metrics().Size >= ty.Metrics().Size {
+	if isFloat(ty) != isFloat(vty) {
+		t := c.Temporary()
+		c.Insn(t, ty.IRBaseTypeName(), "cast", v)
+		return t
+	}
+	if ty.Signed() != vty.Signed() {
+		// Narrow integers must be extended according to their new signedness
+		return truncate(c, v, ty)
+	}
+	return v
+}
+
+// genConvert generates code to convert v from one numeric type to another
+//
+// Integers narrower than a word are held in word temporaries, sign- or zero-extended from their width according to their type.
+// Loads extend them, and operators whose result may not fit in the type wrap it, so that comparisons see the right value.
+//
+// Widening conversions extend the value according to the signedness of the source type.
+// Narrowing conversions truncate the value to the width of the destination, then extend it according to the signedness of the destination.
+// Conversions between types of the same width but different signedness reinterpret the bits.
+// Converting to Bool tests whether the value is nonzero.
+//
+// Implicit conversions never change the width or signedness of a value, except for untyped literals.
+// Operands of different integer types must be converted explicitly with a cast.
+func genConvert(c *Compiler, v Operand, from Type, to ConcreteType) Operand {
+	if _, ok := to.Concrete().(InterfaceType); ok {
+		if _, ok := from.Concrete().(InterfaceType); ok {
+			return v
+		}
+		// Pair the pointer with the vtable of the type it points to
+		t := c.Temporary()
+		c.allocLocal(t, to)
+		genPtrStore(t, v, PointerType{}, c)
+		genPtrStore(genOffset(c, t, 8), c.vtable(pointee(from), to), PointerType{}, c)
+		return t
+	}
+
+	fty, ok := from.Concrete().(NumericType)
+	if !ok {
 		return v
 	}
+	tty, ok := to.Concrete().(NumericType)
+	if !ok {
+		return v
+	}
+	fb, tb := fty.IRBaseTypeName(), tty.IRBaseTypeName()
+	fs, ts := fty.Metrics().Size, tty.Metrics().Size
 
 	var insn string
-	if vty.Signed() {
-		insn = "exts"
-	} else {
-		insn = "extu"
+	switch i, isConst := v.(IRInteger); {
+	case isConst && !isFloat(fty) && !isFloat(tty):
+		n, _ := strconv.ParseInt(string(i), 10, 64)
+		return Constant{Ty: fty, Int: n}.Convert(tty).IR()
+
+	case tty.Equals(TypeBool) && !fty.Equals(TypeBool):
+		zero := Operand(IRInt(0))
+		if isFloat(fty) {
+			zero = IRFloat{fb, "0"}
+		}
+		t := c.Temporary()
+		c.Insn(t, tb, BinCne.Instruction(fty), v, zero)
+		return t
+
+	case isFloat(fty) && isFloat(tty):
+		if f, ok := v.(IRFloat); ok {
+			return IRFloat{tb, f.V}
+		}
+		switch {
+		case fb == tb:
+			return v
+		case fb == 's':
+			insn = "exts"
+		default:
+			insn = "truncd"
+		}
+
+	case isFloat(fty):
+		insn = string(fb) + "to"
+		if tty.Signed() {
+			insn += "si"
+		} else {
+			insn += "ui"
+		}
+
+	case isFloat(tty):
+		if i, ok := v.(IRInteger); ok {
+			return IRFloat{tb, string(i)}
+		}
+		if fty.Signed() {
+			insn = "s"
+		} else {
+			insn = "u"
+		}
+		insn += string(fb) + "tof"
+
+	default:
+		if fs < ts && fb != tb {
+			// Values narrower than a word are already extended to a word
+			t := c.Temporary()
+			c.Insn(t, tb, extInsn(c, fty), v)
+			v = t
+		}
+		if fs > ts || fty.Signed() != tty.Signed() {
+			v = truncate(c, v, tty)
+		}
+		return v
 	}
-	insn += vty.IRTypeName(c)
 
 	t := c.Temporary()
-	c.Insn(t, ty.IRBaseTypeName(), insn, v)
-	return t
+	c.Insn(t, tb, insn, v)
+	return truncate(c, t, tty)
 }
 
-func genPtrStore(ptr, val Operand, ty NumericType, c *Compiler) {
-	// TODO: make extensible
-	c.Insn(0, 0, "store"+ty.IRTypeName(c), val, ptr)
+func genPtrStore(ptr, val Operand, ty ConcreteType, c *Compiler) {
+	if _, ok := ty.Concrete().(NumericType); ok {
+		c.Insn(0, 0, "store"+ty.IRTypeName(c), val, ptr)
+	} else {
+		// Aggregate values are represented by their address
+		ty.GenCopy(c, ptr, val)
+	}
 }
 func genPtrLoad(ptr Operand, ty NumericType, c *Compiler) Operand {
 	op := "load"
@@ -268,6 +1308,17 @@ func genPtrLoad(ptr Operand, ty NumericType, c *Compiler) Operand {
 	c.Insn(tmp, ty.IRBaseTypeName(), op, ptr)
 	return tmp
 }
+
+// genOffset returns a pointer off bytes past ptr
+func genOffset(c *Compiler, ptr Operand, off int) Operand {
+	if off == 0 {
+		return ptr
+	}
+	t := c.Temporary()
+	c.Insn(t, 'l', "add", ptr, IRInt(off))
+	return t
+}
+
 func genLValueExpr(lv LValue, c *Compiler) Operand {
 	ptr, ty := lv.genPointer(c)
 	switch ty := ty.Concrete().(type) {
@@ -286,15 +1337,181 @@ func genLValuePtr(lv LValue, c *Compiler) Operand {
 }
 
 func (e VarExpr) genPointer(c *Compiler) (Operand, Type) {
-	return c.Variable(string(e)).Loc, c.Variable(string(e)).Ty
+	v := c.Variable(string(e))
+	if _, ok := v.Loc.(Constant); ok {
+		panic("Cannot take the address of constant " + string(e))
+	}
+	return v.Loc, v.Ty
 }
 func (e VarExpr) GenPointer(c *Compiler) Operand {
 	return genLValuePtr(e, c)
 }
 func (e VarExpr) GenExpression(c *Compiler) Operand {
+	if k, ok := e.Const(c); ok {
+		return k.IR()
+	}
+	return genLValueExpr(e, c)
+}
+
+func (e InstanceExpr) genPointer(c *Compiler) (Operand, Type) {
+	return e.Loc, e.Ty
+}
+func (e InstanceExpr) GenPointer(c *Compiler) Operand {
+	return genLValuePtr(e, c)
+}
+func (e InstanceExpr) GenExpression(c *Compiler) Operand {
+	return genLValueExpr(e, c)
+}
+
+func (e GenericExpr) genPointer(c *Compiler) (Operand, Type) {
+	return e.instance(c).genPointer(c)
+}
+func (e GenericExpr) GenPointer(c *Compiler) Operand {
+	return genLValuePtr(e, c)
+}
+func (e GenericExpr) GenExpression(c *Compiler) Operand {
+	return genLValueExpr(e, c)
+}
+
+func (e MethodExpr) genPointer(c *Compiler) (Operand, Type) {
+	v, _ := c.method(e.Ty, e.Name)
+	return v.Loc, v.Ty
+}
+func (e MethodExpr) GenPointer(c *Compiler) Operand {
+	return genLValuePtr(e, c)
+}
+func (e MethodExpr) GenExpression(c *Compiler) Operand {
 	return genLValueExpr(e, c)
 }
 
+func (e IndexExpr) genPointer(c *Compiler) (Operand, Type) {
+	ty := e.storageType(c)
+	ptr, n := genSequence(c, e.V)
+	i := genIndexValue(c, e.I)
+	if _, ok := undecayedType(c, e.V).Concrete().(SliceType); ok {
+		genBoundsCheck(c, "cultl", i, n)
+	}
+	return genIndex(c, ptr, i, ty.Concrete().Metrics().Size), ty
+}
+func (e IndexExpr) GenPointer(c *Compiler) Operand {
+	return genLValuePtr(e, c)
+}
+func (e IndexExpr) GenExpression(c *Compiler) Operand {
+	return genLValueExpr(e, c)
+}
+
+func (e SliceExpr) GenExpression(c *Compiler) Operand {
+	ty := e.TypeOf(c).(SliceType)
+	ptr, n := genSequence(c, e.V)
+
+	var lo, hi Operand = IRInt(0), n
+	if e.Lo != nil {
+		lo = genIndexValue(c, e.Lo)
+	}
+	if e.Hi != nil {
+		hi = genIndexValue(c, e.Hi)
+		if n != nil {
+			genBoundsCheck(c, "culel", hi, n)
+		}
+	}
+	genBoundsCheck(c, "culel", lo, hi)
+
+	s := c.Temporary()
+	c.allocLocal(s, ty)
+	genPtrStore(s, genIndex(c, ptr, lo, ty.Ty.Metrics().Size), PointerType{}, c)
+	length := c.Temporary()
+	c.Insn(length, 'l', "sub", hi, lo)
+	genPtrStore(genOffset(c, s, ty.Offset("len")), length, TypeU64, c)
+	return s
+}
+
+func (e SizeofExpr) GenExpression(c *Compiler) Operand {
+	k, _ := e.Const(c)
+	return k.IR()
+}
+func (e AlignofExpr) GenExpression(c *Compiler) Operand {
+	k, _ := e.Const(c)
+	return k.IR()
+}
+func (e OffsetofExpr) GenExpression(c *Compiler) Operand {
+	k, _ := e.Const(c)
+	return k.IR()
+}
+
+func (e LenExpr) GenExpression(c *Compiler) Operand {
+	if k, ok := e.Const(c); ok {
+		return k.IR()
+	}
+	s := e.V.GenExpression(c)
+	return genPtrLoad(genOffset(c, s, SliceType{}.Offset("len")), TypeU64, c)
+}
+
+// genSequence returns a pointer to the first element of an array, pointer or slice, along with its length if known
+func genSequence(c *Compiler, e Expression) (ptr, n Operand) {
+	switch ty := undecayedType(c, e).Concrete().(type) {
+	case ArrayType:
+		ptr, _ = e.(LValue).genPointer(c)
+		return ptr, IRInt(ty.N)
+	case SliceType:
+		s := e.GenExpression(c)
+		ptr = genPtrLoad(s, PointerType{}, c)
+		n = genPtrLoad(genOffset(c, s, ty.Offset("len")), TypeU64, c)
+		return ptr, n
+	default:
+		return e.GenExpression(c), nil
+	}
+}
+
+// genIndexValue generates an index expression, extended to 64 bits
+func genIndexValue(c *Compiler, e Expression) Operand {
+	if k, ok := constValue(c, e); ok {
+		return IRInt(int(k.Int))
+	}
+	return genConvert(c, e.GenExpression(c), e.TypeOf(c), TypeI64)
+}
+
+// genIndex returns a pointer to element i of the sequence at ptr
+func genIndex(c *Compiler, ptr, i Operand, size int) Operand {
+	if k, ok := i.(IRInteger); ok {
+		n, _ := strconv.Atoi(string(k))
+		return genOffset(c, ptr, n*size)
+	}
+	if size != 1 {
+		t := c.Temporary()
+		c.Insn(t, 'l', "mul", i, IRInt(size))
+		i = t
+	}
+	t := c.Temporary()
+	c.Insn(t, 'l', "add", ptr, i)
+	return t
+}
+
+// genBoundsCheck aborts the program if the comparison cmp between a and b fails
+func genBoundsCheck(c *Compiler, cmp string, a, b Operand) {
+	ka, aok := a.(IRInteger)
+	kb, bok := b.(IRInteger)
+	if aok && bok {
+		// Both bounds are known, so check at compile time
+		x, _ := strconv.ParseInt(string(ka), 10, 64)
+		y, _ := strconv.ParseInt(string(kb), 10, 64)
+		if uint64(x) > uint64(y) || cmp == "cultl" && x == y {
+			panic("Index " + string(ka) + " out of range")
+		}
+		return
+	}
+	if c.NoBoundsCheck {
+		return
+	}
+	t := c.Temporary()
+	c.Insn(t, 'w', cmp, a, b)
+	okB := c.Block()
+	failB := c.Block()
+	c.Insn(0, 0, "jnz", t, okB, failB)
+	c.StartBlock(failB)
+	c.Insn(0, 0, "call", CallOperand{Func: Global("abort")})
+	c.StartBlock(okB)
+}
+
 func (e RefExpr) GenExpression(c *Compiler) Operand {
 	return e.V.GenPointer(c)
 }
@@ -310,14 +1527,18 @@ func (e DerefExpr) GenExpression(c *Compiler) Operand {
 }
 
 func (e PrefixExpr) GenExpression(c *Compiler) Operand {
-	t := e.TypeOf(c).Concrete().(NumericType)
+	rt := e.TypeOf(c).Concrete().(NumericType)
+	t := e.V.TypeOf(c).Concrete().(NumericType)
 	v := e.V.GenExpression(c)
 	tmp := c.Temporary()
 	op, arg0 := e.Op.Instruction(c, t)
 	if arg0 == nil {
-		c.Insn(tmp, t.IRBaseTypeName(), op, v)
+		c.Insn(tmp, rt.IRBaseTypeName(), op, v)
 	} else {
-		c.Insn(tmp, t.IRBaseTypeName(), op, arg0, v)
+		c.Insn(tmp, rt.IRBaseTypeName(), op, arg0, v)
+	}
+	if e.Op == PrefNeg || e.Op == PrefInv {
+		return truncate(c, tmp, rt)
 	}
 	return tmp
 }
@@ -331,6 +1552,9 @@ func (op PrefixOperator) Instruction(c *Compiler, ty NumericType) (string, Opera
 	case PrefInv:
 		return "xor", IRInt(-1)
 	case PrefNeg:
+		if isFloat(ty) {
+			return "neg", nil
+		}
 		return "sub", IRInt(0)
 	case PrefPos:
 		return "copy", nil
@@ -339,21 +1563,36 @@ func (op PrefixOperator) Instruction(c *Compiler, ty NumericType) (string, Opera
 }
 
 func (e BinaryExpr) GenExpression(c *Compiler) Operand {
-	ty := e.TypeOf(c).Concrete().(NumericType)
+	ty := e.operandType(c).Concrete().(NumericType)
 	l := e.L.GenExpression(c)
 	r := e.R.GenExpression(c)
 	return e.Op.genExpression(c, l, r, e.L.TypeOf(c), e.R.TypeOf(c), ty)
 }
 
+// extend extends v from the width of ty to 64 bits
 func extend(c *Compiler, v Operand, ty NumericType) Operand {
 	t := c.Temporary()
-	signed := "u"
-	if ty.Signed() {
-		signed = "s"
+	c.Insn(t, 'l', extInsn(c, ty), v)
+	return t
+}
+
+// truncate wraps v to the range of ty, if ty is an integer type narrower than a word
+func truncate(c *Compiler, v Operand, ty NumericType) Operand {
+	if isFloat(ty) || ty.Metrics().Size >= 4 {
+		return v
 	}
-	c.Insn(t, 'l', "ext"+signed+ty.IRTypeName(c), v)
+	t := c.Temporary()
+	c.Insn(t, 'w', extInsn(c, ty), v)
 	return t
 }
+
+// extInsn returns the instruction that extends a value from the width of ty, according to its signedness
+func extInsn(c *Compiler, ty NumericType) string {
+	if ty.Signed() {
+		return "exts" + ty.IRTypeName(c)
+	}
+	return "extu" + ty.IRTypeName(c)
+}
 func ptrMul(c *Compiler, v Operand, ty PointerType) Operand {
 	if ty.To == nil {
 		return v
@@ -377,10 +1616,17 @@ func (op BinaryOperator) genExpression(c *Compiler, l, r Operand, lty, rty Type,
 		if lsiz > rsiz {
 			r = extend(c, r, rty.Concrete().(NumericType))
 		} else if rsiz > lsiz {
-			l = extend(c, r, lty.Concrete().(NumericType))
+			l = extend(c, l, lty.Concrete().(NumericType))
 		}
 	}
 
+	if !lty.IsConcrete() {
+		l = genConvert(c, l, lty, ty)
+	}
+	if !rty.IsConcrete() {
+		r = genConvert(c, r, rty, ty)
+	}
+
 	if !lptr && rptr {
 		l = ptrMul(c, l, rpty)
 	}
@@ -388,14 +1634,34 @@ func (op BinaryOperator) genExpression(c *Compiler, l, r Operand, lty, rty Type,
 		r = ptrMul(c, r, lpty)
 	}
 
+	rety := ty.IRBaseTypeName()
+	if op.Compare() {
+		rety = TypeBool.IRBaseTypeName()
+	}
+
 	v := c.Temporary()
-	c.Insn(v, ty.IRBaseTypeName(), op.Instruction(ty), l, r)
+	c.Insn(v, rety, op.Instruction(ty), l, r)
+	switch op {
+	case BinAdd, BinSub, BinMul, BinDiv, BinShl:
+		// The result may not fit in a narrow type
+		return truncate(c, v, ty)
+	}
 	return v
 }
 
 var _ = [1]int{0}[BinaryOperatorMax-17] // Assert correct number of binary operators
 func (op BinaryOperator) Instruction(ty NumericType) string {
 	ity := string(ty.IRBaseTypeName())
+	cmp := func(name string) string {
+		switch {
+		case isFloat(ty):
+			return "c" + name + ity
+		case ty.Signed():
+			return "cs" + name + ity
+		default:
+			return "cu" + name + ity
+		}
+	}
 	switch op {
 	case BinAdd:
 		return "add"
@@ -436,29 +1702,13 @@ func (op BinaryOperator) Instruction(ty NumericType) string {
 	case BinCne:
 		return "cne" + ity
 	case BinClt:
-		if ty.Signed() {
-			return "cslt" + ity
-		} else {
-			return "cult" + ity
-		}
+		return cmp("lt")
 	case BinCgt:
-		if ty.Signed() {
-			return "csgt" + ity
-		} else {
-			return "cugt" + ity
-		}
+		return cmp("gt")
 	case BinCle:
-		if ty.Signed() {
-			return "csle" + ity
-		} else {
-			return "cule" + ity
-		}
+		return cmp("le")
 	case BinCge:
-		if ty.Signed() {
-			return "csge" + ity
-		} else {
-			return "cuge" + ity
-		}
+		return cmp("ge")
 	}
 	panic("Invalid binary operator")
 }
@@ -466,7 +1716,7 @@ func (op BinaryOperator) Instruction(ty NumericType) string {
 func (e BooleanExpr) GenExpression(c *Compiler) Operand {
 	t := e.TypeOf(c).Concrete().(NumericType)
 
-	l := e.L.GenExpression(c)
+	l := genBool(c, "Operand of "+e.Op.String(), e.L)
 	v := c.Temporary()
 	c.Insn(v, t.IRBaseTypeName(), "copy", l)
 
@@ -475,7 +1725,7 @@ func (e BooleanExpr) GenExpression(c *Compiler) Operand {
 	e.Op.Emit(c, v, longB, shortB)
 
 	c.StartBlock(longB)
-	r := e.R.GenExpression(c)
+	r := genBool(c, "Operand of "+e.Op.String(), e.R)
 	c.Insn(v, t.IRBaseTypeName(), "copy", r)
 
 	c.StartBlock(shortB)
@@ -497,11 +1747,55 @@ func (op BooleanOperator) Emit(c *Compiler, v Operand, longB, shortB Block) {
 	c.Insn(0, 0, "jnz", v, a, b)
 }
 
+func (e InitExpr) GenExpression(c *Compiler) Operand {
+	panic("Initializer list used without a type")
+}
+func (_ TupleExpr) GenExpression(c *Compiler) Operand {
+	panic("Multiple values used in single-value context")
+}
+func (e KeyedExpr) GenExpression(c *Compiler) Operand {
+	panic("Field name " + e.Name + " used outside of an initializer list")
+}
+func (e CompositeExpr) GenExpression(c *Compiler) Operand {
+	ty := e.Ty.Get(c)
+	t := c.Temporary()
+	c.allocLocal(t, ty)
+	e.Init.genInit(c, t, ty)
+	return t
+}
+
+func (e FuncExpr) GenExpression(c *Compiler) Operand {
+	return e.hoist(c)
+}
+
+// function returns the function a function literal is compiled to
+func (e FuncExpr) function() Function {
+	return Function{Param: e.Param, Ret: e.Ret, Body: e.Body}
+}
+
+// hoist queues a function literal to be compiled as a private function, and returns its address
+func (e FuncExpr) hoist(c *Compiler) Global {
+	f := e.function()
+	f.Name = "fn." + strconv.Itoa(c.lits)
+	c.lits++
+
+	// Compile the function in the namespace the literal appears in, but without access to local variables
+	ns := append([]Namespace(nil), c.ns...)
+	c.pend = append(c.pend, func() {
+		cur := c.ns
+		c.ns = ns
+		f.GenToplevel(c)
+		c.ns = cur
+	})
+	return Global(c.NS().Name + f.Name)
+}
+
 func (e IntegerExpr) GenExpression(c *Compiler) Operand {
-	return IRInteger(e)
+	return e.value().IR()
 }
 func (e FloatExpr) GenExpression(c *Compiler) Operand {
-	panic("TODO")
+	s, _ := splitSuffix(string(e), "f")
+	return IRFloat{e.value().Ty.Concrete().IRBaseTypeName(), s}
 }
 func (e StringExpr) GenExpression(c *Compiler) Operand {
 	return c.String(string(e))
@@ -517,47 +1811,124 @@ func (p PointerType) GenZero(c *Compiler, loc Operand) {
 	c.Insn(0, 0, "storel", IRInt(0), loc)
 }
 func (a ArrayType) GenZero(c *Compiler, loc Operand) {
-	off := 0
 	m := a.Ty.Metrics()
 	for i := 0; i < a.N; i++ {
-		op := loc
-		if off > 0 {
-			t := c.Temporary()
-			c.Insn(t, 'l', "add", loc, IRInt(off))
-			op = t
-		}
-		a.Ty.GenZero(c, op)
-		off += m.Size
+		a.Ty.GenZero(c, genOffset(c, loc, i*m.Size))
 	}
 }
+func (s SliceType) GenZero(c *Compiler, loc Operand) {
+	s.repr().GenZero(c, loc)
+}
+func (t TupleType) GenZero(c *Compiler, loc Operand) {
+	t.repr().GenZero(c, loc)
+}
 func (f FuncType) GenZero(c *Compiler, loc Operand) {
 	panic("Attempted to zero a function type")
 }
 
+func (e EnumType) GenZero(c *Compiler, loc Operand) {
+	e.Ty.GenZero(c, loc)
+}
+func (t NamedType) GenZero(c *Compiler, loc Operand) {
+	t.underlying().GenZero(c, loc)
+}
 func (s StructType) GenZero(c *Compiler, loc Operand) {
 	off := 0
 	for _, field := range s.compositeType {
 		off = -(-off & -field.Ty.Metrics().Align) // Align upwards
-		floc := loc
-		if off > 0 {
-			ftmp := c.Temporary()
-			c.Insn(ftmp, 'l', "add", loc, IRInt(off))
-			floc = ftmp
-		}
-		field.Ty.GenZero(c, floc)
+		field.Ty.GenZero(c, genOffset(c, loc, off))
 		off += field.Ty.Metrics().Size
 	}
 }
 
 func (u UnionType) GenZero(c *Compiler, loc Operand) {
-	var maxTy ConcreteType
-	var maxSize int
-	for _, field := range u.compositeType {
-		size := field.Ty.Metrics().Size
-		if size > maxSize {
-			maxTy = field.Ty
-			maxSize = size
-		}
+	// Zero the whole block, since no member need cover the padding at the end
+	genBlockZero(c, loc, u.Metrics())
+}
+
+func (v VariantType) GenZero(c *Compiler, loc Operand) {
+	v.repr().GenZero(c, loc)
+}
+func (iface InterfaceType) GenZero(c *Compiler, loc Operand) {
+	iface.repr().GenZero(c, loc)
+}
+
+func (p PrimitiveType) GenCopy(c *Compiler, dst, src Operand) {
+	genPtrStore(dst, genPtrLoad(src, p, c), p, c)
+}
+func (p PointerType) GenCopy(c *Compiler, dst, src Operand) {
+	genPtrStore(dst, genPtrLoad(src, p, c), p, c)
+}
+func (a ArrayType) GenCopy(c *Compiler, dst, src Operand) {
+	m := a.Ty.Metrics()
+	for i := 0; i < a.N; i++ {
+		a.Ty.GenCopy(c, genOffset(c, dst, i*m.Size), genOffset(c, src, i*m.Size))
+	}
+}
+func (s SliceType) GenCopy(c *Compiler, dst, src Operand) {
+	s.repr().GenCopy(c, dst, src)
+}
+func (t TupleType) GenCopy(c *Compiler, dst, src Operand) {
+	t.repr().GenCopy(c, dst, src)
+}
+func (f FuncType) GenCopy(c *Compiler, dst, src Operand) {
+	panic("Attempted to copy a function type")
+}
+
+func (e EnumType) GenCopy(c *Compiler, dst, src Operand) {
+	e.Ty.GenCopy(c, dst, src)
+}
+func (t NamedType) GenCopy(c *Compiler, dst, src Operand) {
+	t.underlying().GenCopy(c, dst, src)
+}
+func (s StructType) GenCopy(c *Compiler, dst, src Operand) {
+	off := 0
+	for _, field := range s.compositeType {
+		off = -(-off & -field.Ty.Metrics().Align) // Align upwards
+		field.Ty.GenCopy(c, genOffset(c, dst, off), genOffset(c, src, off))
+		off += field.Ty.Metrics().Size
+	}
+}
+
+func (u UnionType) GenCopy(c *Compiler, dst, src Operand) {
+	// We don't know which field is active, so copy the whole block
+	genBlockCopy(c, dst, src, u.Metrics())
+}
+
+func (v VariantType) GenCopy(c *Compiler, dst, src Operand) {
+	v.repr().GenCopy(c, dst, src)
+}
+func (iface InterfaceType) GenCopy(c *Compiler, dst, src Operand) {
+	iface.repr().GenCopy(c, dst, src)
+}
+
+// genBlockCopy copies a block of memory in units of its alignment
+func genBlockCopy(c *Compiler, dst, src Operand, m TypeMetrics) {
+	unit := blockUnit(m)
+	step := unit.Metrics().Size
+	for off := 0; off < m.Size; off += step {
+		unit.GenCopy(c, genOffset(c, dst, off), genOffset(c, src, off))
+	}
+}
+
+// genBlockZero zeroes a block of memory in units of its alignment
+func genBlockZero(c *Compiler, loc Operand, m TypeMetrics) {
+	unit := blockUnit(m)
+	step := unit.Metrics().Size
+	for off := 0; off < m.Size; off += step {
+		unit.GenZero(c, genOffset(c, loc, off))
+	}
+}
+
+// blockUnit returns the largest integer type that divides a block with metrics m
+func blockUnit(m TypeMetrics) PrimitiveType {
+	switch {
+	case m.Align >= 8:
+		return TypeU64
+	case m.Align >= 4:
+		return TypeU32
+	case m.Align >= 2:
+		return TypeU16
 	}
-	maxTy.GenZero(c, loc)
+	return TypeU8
 }
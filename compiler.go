@@ -10,19 +10,34 @@ import (
 )
 
 type Compiler struct {
+	NoBoundsCheck bool // Disable runtime bounds checks on slices
+	StrictBool    bool // Require Bool conditions
+
 	r *CompileResult
 
-	blk  Block
-	temp Temporary
-	ret  bool // True if the last emitted instruction was `ret`
+	blk   Block
+	temp  Temporary
+	ret   bool         // True if the last emitted instruction was `ret`
+	retTy ConcreteType // Return type of the current function
+
+	loop []Loop         // Loop stack
+	ns   []Namespace    // Namespace stack
+	comp []TypeLayout   // Composite types
+	vars []Scope        // Local variable scope stack
+	strs []IRString     // String constants
+	strM map[string]int // Map from string to index of entry in strs
+	data []IRData       // Global data
+	datM map[Global]int // Map from global to index of entry in data
+
+	vtbM map[string]Global // Map from type and interface to vtable
+	thks []thunk           // Thunks for methods with value receivers used in vtables
 
-	loop []Loop              // Loop stack
-	ns   []Namespace         // Namespace stack
-	comp []CompositeLayout   // Composite types
-	vars map[string]Variable // Local variable names
-	strs []IRString          // String constants
-	strM map[string]int      // Map from string to index of entry in strs
-	data []Variable          // Global data
+	gens map[string]*GenericType // Generic types, by qualified name
+	inst map[string]interface{}  // Instances of generic types and functions, by name
+	pend []func()                // Generic instances and function literals that have not been compiled yet
+	lits int                     // Number of function literals
+
+	defs map[interface{}]func() // Definitions of types and constants that have not been compiled yet
 }
 
 type CompileResult struct {
@@ -53,7 +68,7 @@ func NewCompiler() *Compiler {
 	c.r = &CompileResult{}
 	c.ns = []Namespace{{
 		"", map[string]Type{},
-		map[string]*ConcreteType{},
+		map[string]*ConcreteType{}, map[string]*Constant{},
 	}}
 
 	baseTypes := map[string]ConcreteType{
@@ -76,9 +91,15 @@ func NewCompiler() *Compiler {
 		ty2 := ty // Copy so we can get a pointer to it
 		c.ns[0].Typs[name] = &ty2
 	}
+	c.ns[0].Consts["true"] = &Constant{Ty: TypeBool, Int: 1}
+	c.ns[0].Consts["false"] = &Constant{Ty: TypeBool, Int: 0}
 
-	c.vars = map[string]Variable{}
 	c.strM = map[string]int{}
+	c.datM = map[Global]int{}
+	c.vtbM = map[string]Global{}
+	c.gens = map[string]*GenericType{}
+	c.inst = map[string]interface{}{}
+	c.defs = map[interface{}]func(){}
 	return c
 }
 
@@ -108,6 +129,15 @@ func (c *Compiler) Writef(format string, args ...interface{}) {
 }
 
 func (c *Compiler) Insn(retVar Temporary, retType byte, opcode string, operands ...Operand) {
+	c.insn(retVar, string(retType), opcode, operands...)
+}
+
+// AggregateInsn is like Insn, but for instructions returning an aggregate type
+func (c *Compiler) AggregateInsn(retVar Temporary, retType string, opcode string, operands ...Operand) {
+	c.insn(retVar, retType, opcode, operands...)
+}
+
+func (c *Compiler) insn(retVar Temporary, retType string, opcode string, operands ...Operand) {
 	// Skip all instructions after ret since they're unreachable
 	if c.ret {
 		return
@@ -126,7 +156,7 @@ func (c *Compiler) Insn(retVar Temporary, retType byte, opcode string, operands
 	if retVar.IsZero() {
 		c.Writef("\t%s\n", b)
 	} else {
-		c.Writef("\t%s =%c %s\n", retVar, retType, b)
+		c.Writef("\t%s =%s %s\n", retVar, retType, b)
 	}
 
 	c.ret = opcode == "ret"
@@ -134,8 +164,14 @@ func (c *Compiler) Insn(retVar Temporary, retType byte, opcode string, operands
 
 func (c *Compiler) StartNamespace(name string) {
 	cur := c.NS()
-	ns := Namespace{cur.Name + name + ".", map[string]Type{}, map[string]*ConcreteType{}}
-	cur.Vars[name] = ns
+	ns, ok := cur.Vars[name].(Namespace)
+	if !ok {
+		ns = Namespace{
+			cur.Name + name + ".", map[string]Type{},
+			map[string]*ConcreteType{}, map[string]*Constant{},
+		}
+		cur.Vars[name] = ns
+	}
 	c.ns = append(c.ns, ns)
 }
 func (c *Compiler) EndNamespace() {
@@ -148,7 +184,7 @@ func (c *Compiler) NS() Namespace {
 	return c.ns[len(c.ns)-1]
 }
 
-func (c *Compiler) StartFunction(export bool, name string, params []IRParam, retType string) {
+func (c *Compiler) StartFunction(export bool, name string, params []IRParam, ret ConcreteType) {
 	prefix := ""
 	if export {
 		prefix = "export "
@@ -172,6 +208,10 @@ func (c *Compiler) StartFunction(export bool, name string, params []IRParam, ret
 		pbuild.WriteString(ptemps[i].Operand())
 	}
 
+	var retType string
+	if ret != nil {
+		retType = ret.IRTypeName(c)
+	}
 	if retType == "b" || retType == "h" {
 		retType = "w"
 	}
@@ -179,7 +219,9 @@ func (c *Compiler) StartFunction(export bool, name string, params []IRParam, ret
 		retType += " "
 	}
 	name = c.NS().Name + name
+	c.retTy = ret
 	c.Writef("%sfunction %s$%s(%s) {\n@start\n", prefix, retType, name, pbuild)
+	c.StartScope()
 
 	// Add args to locals
 	for i, param := range params {
@@ -190,7 +232,7 @@ func (c *Compiler) StartFunction(export bool, name string, params []IRParam, ret
 			c.allocLocal(loc, param.Ty)
 			c.Insn(0, 0, "store"+param.Ty.IRTypeName(c), ptemps[i], loc)
 		}
-		c.vars[param.Name] = Variable{loc, param.Ty}
+		c.Scope()[param.Name] = Variable{loc, param.Ty}
 	}
 }
 
@@ -204,7 +246,13 @@ func (c *Compiler) EndFunction() {
 	c.temp = 0
 	c.blk = 0
 	c.ret = false
-	c.vars = map[string]Variable{}
+	c.retTy = nil
+	c.vars = nil
+}
+
+// ReturnType returns the return type of the current function
+func (c *Compiler) ReturnType() ConcreteType {
+	return c.retTy
 }
 
 type IRParam struct {
@@ -231,6 +279,16 @@ func (c *Compiler) Loop() Loop {
 	return c.loop[len(c.loop)-1]
 }
 
+func (c *Compiler) StartScope() {
+	c.vars = append(c.vars, Scope{})
+}
+func (c *Compiler) EndScope() {
+	c.vars = c.vars[:len(c.vars)-1]
+}
+func (c *Compiler) Scope() Scope {
+	return c.vars[len(c.vars)-1]
+}
+
 func (c *Compiler) Temporary() Temporary {
 	c.temp++
 	return c.temp
@@ -238,19 +296,79 @@ func (c *Compiler) Temporary() Temporary {
 
 func (c *Compiler) AliasType(name string) *ConcreteType {
 	cur := c.NS()
-	if _, ok := cur.Typs[name]; ok {
+	if _, ok := cur.Typs[name]; ok || c.gens[cur.Name+name] != nil {
 		panic("Type already exists")
 	}
 	ty := new(ConcreteType)
 	cur.Typs[name] = ty
 	return ty
 }
+
+// Declare a type in the current namespace, returning its slot
+// define is called to compile the definition of the type when it is first used
+func (c *Compiler) DeclareType(name string, define func(slot *ConcreteType)) *ConcreteType {
+	slot := c.AliasType(name)
+	c.declare(slot, func() { define(slot) })
+	return slot
+}
+
+// Declare a constant in the current scope, or namespace if outside a function
+// define is called to evaluate the constant when it is first used
+func (c *Compiler) DeclareConst(name string, define func() Constant) {
+	if len(c.vars) > 0 {
+		// Local constants are evaluated immediately, before the name comes into scope
+		k := define()
+		if _, ok := c.Scope()[name]; ok {
+			panic("Variable already exists")
+		}
+		c.Scope()[name] = Variable{k, k.Ty}
+		return
+	}
+
+	cur := c.NS()
+	if _, ok := cur.Vars[name]; ok || cur.Consts[name] != nil {
+		panic("Variable already exists")
+	}
+	slot := new(Constant)
+	cur.Consts[name] = slot
+	c.declare(slot, func() { *slot = define() })
+}
+
+// Register the definition of a type or constant to be compiled later
+func (c *Compiler) declare(slot interface{}, define func()) {
+	ns := append([]Namespace(nil), c.ns...)
+	c.defs[slot] = func() {
+		// Compile the definition in the namespace it was declared in
+		cur := c.ns
+		c.ns = ns
+		define()
+		c.ns = cur
+	}
+}
+
+// Compile the definition of a type or constant, if it hasn't been already
+func (c *Compiler) define(slot interface{}) {
+	if define, ok := c.defs[slot]; ok {
+		// Remove the definition first, so recursive references see the incomplete value
+		delete(c.defs, slot)
+		define()
+	}
+}
+
+func (c *Compiler) resolveType(name string, ty *ConcreteType) ConcreteType {
+	c.define(ty)
+	if *ty == nil {
+		panic("Type " + name + " refers to itself")
+	}
+	return *ty
+}
+
 func (c *Compiler) Type(path ...string) ConcreteType {
 	name, path := path[len(path)-1], path[:len(path)-1]
 
 	if len(path) == 0 {
 		if ty, ok := c.NS().Typs[name]; ok {
-			return *ty
+			return c.resolveType(name, ty)
 		}
 	}
 
@@ -262,13 +380,149 @@ func (c *Compiler) Type(path ...string) ConcreteType {
 		}
 	}
 	if ty, ok := ns.Typs[name]; ok {
-		return *ty
+		return c.resolveType(name, ty)
 	}
 
+	if c.gens[ns.Name+name] != nil || len(path) == 0 && c.gens[c.NS().Name+name] != nil {
+		panic("Generic type " + name + " used without type arguments")
+	}
 	panic("Unknown type: " + name)
 }
 
-func (c *Compiler) CompositeType(layout CompositeLayout) string {
+// A generic type definition, which is instantiated for each list of type arguments
+type GenericType struct {
+	TypeDef
+	name string      // Qualified name
+	ns   []Namespace // The namespace stack the type was declared in
+}
+
+// Declare a generic type in the current namespace
+func (c *Compiler) DeclareGenericType(t TypeDef) {
+	cur := c.NS()
+	if _, ok := cur.Typs[t.Name]; ok || c.gens[cur.Name+t.Name] != nil {
+		panic("Type already exists")
+	}
+	ns := append([]Namespace(nil), c.ns...)
+	c.gens[cur.Name+t.Name] = &GenericType{t, cur.Name + t.Name, ns}
+}
+
+// Look up a generic type
+func (c *Compiler) Generic(path ...string) *GenericType {
+	name, path := path[len(path)-1], path[:len(path)-1]
+	if len(path) == 0 {
+		if g := c.gens[c.NS().Name+name]; g != nil {
+			return g
+		}
+	}
+	if g := c.gens[strings.Join(append(path, name), ".")]; g != nil {
+		return g
+	}
+	panic("Unknown generic type: " + name)
+}
+
+// Instantiate returns the instance of a generic type for the given type arguments
+func (g *GenericType) Instantiate(c *Compiler, args []ConcreteType) NamedType {
+	checkTypeArgs(g.name, g.TypeParam, args)
+	name := g.name + fmtTypeArgs(args)
+	if inst, ok := c.inst[name]; ok {
+		return inst.(typeInstance).ty
+	}
+
+	// Record the instance before compiling it, so it can refer to itself
+	named := NamedType{name, new(ConcreteType)}
+	c.inst[name] = typeInstance{g, args, named}
+	c.withTypes(g.ns, g.TypeParam, args, func() {
+		*named.ty = g.Ty.Get(c)
+	})
+	if named.embeddedIn(*named.ty) {
+		panic("Type " + name + " contains itself")
+	}
+	return named
+}
+
+type typeInstance struct {
+	gen  *GenericType
+	args []ConcreteType
+	ty   NamedType
+}
+
+// Instantiate returns the instance of a generic function for the given type arguments
+// The body of the instance is compiled when the compiler finishes
+func (f GenericFunc) Instantiate(c *Compiler, args []ConcreteType) InstanceExpr {
+	checkTypeArgs(f.Name, f.TypeParam, args)
+	ns := f.ns[len(f.ns)-1].Name
+	name := f.Name + fmtTypeArgs(args)
+	if inst, ok := c.inst[ns+name]; ok {
+		return inst.(InstanceExpr)
+	}
+
+	// Mangle the type arguments into something QBE accepts
+	mangled := f.Name
+	for _, arg := range args {
+		mangled += "." + mangle(arg.Format(0))
+	}
+
+	fn := f.Function
+	fn.Name, fn.TypeParam = mangled, nil
+	inst := InstanceExpr{Name: name, Loc: Global(ns + mangled)}
+	c.withTypes(f.ns, f.TypeParam, args, func() {
+		inst.Ty = fn.typ(c)
+	})
+	c.inst[ns+name] = inst
+	c.pend = append(c.pend, func() {
+		c.withTypes(f.ns, f.TypeParam, args, func() {
+			fn.GenToplevel(c)
+		})
+	})
+	return inst
+}
+
+func checkTypeArgs(name string, params []string, args []ConcreteType) {
+	if len(args) != len(params) {
+		panic(fmt.Sprintf("Wrong number of type arguments for %s: expected %d, got %d", name, len(params), len(args)))
+	}
+}
+
+func fmtTypeArgs(args []ConcreteType) string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.Format(0)
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
+
+// mangle escapes every character of a type name that is not valid in a QBE identifier
+func mangle(name string) string {
+	b := &strings.Builder{}
+	for _, ch := range []byte(name) {
+		if 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '.' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(b, "_%02x", ch)
+		}
+	}
+	return b.String()
+}
+
+// withTypes runs f in the namespace stack ns, with the type parameters params bound to args
+func (c *Compiler) withTypes(ns []Namespace, params []string, args []ConcreteType, f func()) {
+	cur := ns[len(ns)-1]
+	typs := make(map[string]*ConcreteType, len(cur.Typs)+len(params))
+	for name, ty := range cur.Typs {
+		typs[name] = ty
+	}
+	for i, name := range params {
+		ty := args[i]
+		typs[name] = &ty
+	}
+
+	saved := c.ns
+	c.ns = append(append([]Namespace(nil), ns[:len(ns)-1]...), Namespace{cur.Name, cur.Vars, typs, cur.Consts})
+	f()
+	c.ns = saved
+}
+
+func (c *Compiler) CompositeType(layout TypeLayout) string {
 	ident := layout.Ident()
 	for i, layout_ := range c.comp {
 		switch strings.Compare(layout_.Ident(), ident) {
@@ -290,13 +544,24 @@ func (c *Compiler) CompositeType(layout CompositeLayout) string {
 
 func (c *Compiler) DeclareGlobal(extern bool, name string, ty ConcreteType) {
 	cur := c.NS()
-	if _, ok := cur.Vars[name]; ok {
+	if _, ok := cur.Vars[name]; ok || cur.Consts[name] != nil {
 		panic("Variable already exists")
 	}
 	cur.Vars[name] = ty
 	if !extern {
-		c.data = append(c.data, Variable{Global(cur.Name + name), ty})
+		loc := Global(cur.Name + name)
+		c.datM[loc] = len(c.data)
+		c.data = append(c.data, IRData{loc, ty, nil})
+	}
+}
+
+// Set the initial value of a global variable previously declared in the current namespace
+func (c *Compiler) DefineGlobal(name string, items []IRDataItem) {
+	i, ok := c.datM[Global(c.NS().Name+name)]
+	if !ok {
+		panic("Cannot initialize undefined variable " + name)
 	}
+	c.data[i].Items = items
 }
 func (c *Compiler) allocLocal(loc Temporary, ty ConcreteType) {
 	m := ty.Metrics()
@@ -314,33 +579,124 @@ func (c *Compiler) allocLocal(loc Temporary, ty ConcreteType) {
 	c.Insn(loc, 'l', op, IRInt(m.Size))
 }
 func (c *Compiler) DeclareLocal(name string, ty ConcreteType) {
-	if _, ok := c.vars[name]; ok {
-		panic("Variable already exists")
-	}
-	loc := c.Temporary()
-	c.vars[name] = Variable{loc, ty}
+	ty.GenZero(c, c.DefineLocal(name, ty))
+}
 
+// Allocate a local variable without initializing it
+func (c *Compiler) DefineLocal(name string, ty ConcreteType) Temporary {
+	loc := c.Temporary()
+	c.bindLocal(name, loc, ty)
 	c.allocLocal(loc, ty)
-	ty.GenZero(c, loc)
+	return loc
 }
-func (c *Compiler) nsVar(i int, name string) (Variable, bool) {
-	if ty, ok := c.ns[i].Vars[name]; ok {
-		return Variable{Global(c.ns[i].Name + name), ty}, true
+
+// Bring a local variable stored at loc into scope
+func (c *Compiler) bindLocal(name string, loc Temporary, ty ConcreteType) {
+	if _, ok := c.Scope()[name]; ok {
+		panic("Variable already exists")
+	}
+	c.Scope()[name] = Variable{loc, ty}
+}
+func (c *Compiler) nsVar(ns Namespace, name string) (Variable, bool) {
+	if k, ok := ns.Consts[name]; ok {
+		c.define(k)
+		if k.Ty == nil {
+			panic("Constant " + ns.Name + name + " refers to itself")
+		}
+		return Variable{*k, k.Ty}, true
+	}
+	if ty, ok := ns.Vars[name]; ok {
+		return Variable{Global(ns.Name + name), ty}, true
 	}
 	return Variable{}, false
 }
-func (c *Compiler) Variable(name string) Variable {
-	if v, ok := c.vars[name]; ok {
+
+// Look up a variable, function, constant or namespace within a namespace
+func (c *Compiler) nsMember(ns Namespace, name string) Variable {
+	if v, ok := c.nsVar(ns, name); ok {
 		return v
 	}
-	if v, ok := c.nsVar(len(c.ns)-1, name); ok {
-		return v
+	panic("Undefined variable: " + ns.Name + name)
+}
+
+// Return the vtable of the methods of ty that implement iface, creating it if needed
+func (c *Compiler) vtable(ty NamedType, iface ConcreteType) Global {
+	key := ty.Name + " " + iface.Format(0)
+	if loc, ok := c.vtbM[key]; ok {
+		return loc
 	}
-	if v, ok := c.nsVar(0, name); ok {
+
+	methods := iface.Concrete().(InterfaceType).compositeType
+	items := make([]IRDataItem, len(methods))
+	for i, m := range methods {
+		errPrefix := ty.Name + " does not implement " + iface.Format(0)
+		v, _ := c.method(ty, m.Name)
+		fn, ok := v.Ty.(FuncType)
+		if !ok || len(fn.Param) == 0 {
+			panic(errPrefix + ": missing method " + m.Name)
+		}
+		if sig := (FuncType{fn.Var, fn.Param[1:], fn.Ret}); !sig.Equals(m.Ty) {
+			panic(errPrefix + ": method " + m.Name + " has type " + sig.Format(0) + ", not " + m.Ty.Format(0))
+		}
+
+		loc := v.Loc.(Global)
+		if fn.Param[0].Equals(ty) {
+			// The method takes its receiver by value, so it needs a thunk to dereference the data pointer
+			t := thunk{ty, m.Name, fn}
+			loc = t.Global()
+			if !c.hasThunk(loc) {
+				c.thks = append(c.thks, t)
+			}
+		}
+		items[i] = IRDataItem{"l", loc.Operand()}
+	}
+
+	// The dot keeps the name from colliding with user globals
+	loc := Global("vtable." + strconv.Itoa(len(c.vtbM)))
+	c.vtbM[key] = loc
+	c.datM[loc] = len(c.data)
+	c.data = append(c.data, IRData{loc, ArrayType{PointerType{}, len(methods)}, items})
+	return loc
+}
+
+func (c *Compiler) hasThunk(loc Global) bool {
+	for _, t := range c.thks {
+		if t.Global() == loc {
+			return true
+		}
+	}
+	return false
+}
+
+// Look up a method of a named type
+func (c *Compiler) method(ty NamedType, name string) (Variable, bool) {
+	ns := c.ns[0]
+	for _, elem := range strings.Split(ty.Name, ".") {
+		var ok bool
+		if ns, ok = ns.Vars[elem].(Namespace); !ok {
+			return Variable{}, false
+		}
+	}
+	return c.nsVar(ns, name)
+}
+
+func (c *Compiler) Variable(name string) Variable {
+	if v, ok := c.lookupVariable(name); ok {
 		return v
 	}
 	panic("Undefined variable: " + name)
 }
+func (c *Compiler) lookupVariable(name string) (Variable, bool) {
+	for i := len(c.vars) - 1; i >= 0; i-- {
+		if v, ok := c.vars[i][name]; ok {
+			return v, true
+		}
+	}
+	if v, ok := c.nsVar(c.NS(), name); ok {
+		return v, true
+	}
+	return c.nsVar(c.ns[0], name)
+}
 
 func (c *Compiler) String(str string) Global {
 	i, ok := c.strM[str]
@@ -353,10 +709,37 @@ func (c *Compiler) String(str string) Global {
 }
 
 func (c *Compiler) Finish() {
-	// Write composite types
+	// Write instances of generic functions and function literals, which may add more of either
+	for i := 0; i < len(c.pend); i++ {
+		c.pend[i]()
+	}
+
+	// Write thunks before types, since they may use new composite types
+	for _, t := range c.thks {
+		t.gen(c)
+	}
+
+	// Write composite types, making sure each is defined before it is referenced
+	layouts := make(map[string]TypeLayout, len(c.comp))
 	for _, layout := range c.comp {
+		layouts[layout.Ident()] = layout
+	}
+	done := map[string]bool{}
+	var genType func(layout TypeLayout)
+	genType = func(layout TypeLayout) {
+		ident := layout.Ident()
+		if done[ident] {
+			return
+		}
+		done[ident] = true
+		for _, ref := range layout.Refs() {
+			genType(layouts[ref])
+		}
 		layout.GenType(c)
 	}
+	for _, layout := range c.comp {
+		genType(layout)
+	}
 
 	// Write strings
 	for i, str := range c.strs {
@@ -366,10 +749,27 @@ func (c *Compiler) Finish() {
 	// Write global data
 	for _, data := range c.data {
 		m := data.Ty.Concrete().Metrics()
-		c.Writef("data %s = align %d { z %d }\n", data.Loc, m.Align, m.Size)
+		items := data.Items
+		if items == nil {
+			items = []IRDataItem{{"z", strconv.Itoa(m.Size)}}
+		}
+		strs := make([]string, len(items))
+		for i, item := range items {
+			strs[i] = item.Ty + " " + item.V
+		}
+		c.Writef("data %s = align %d { %s }\n", data.Loc, m.Align, strings.Join(strs, ", "))
 	}
 }
 
+// TypeLayout is the layout of a QBE aggregate type
+type TypeLayout interface {
+	// The name of the type, derived from its layout
+	Ident() string
+	// The names of the aggregate types referenced by the layout
+	Refs() []string
+	GenType(c *Compiler)
+}
+
 type CompositeLayout []CompositeEntry
 type CompositeEntry struct {
 	Ty string
@@ -383,7 +783,7 @@ func (l CompositeLayout) Ident() string {
 		if len(entry.Ty) > 1 {
 			// X and Y act as parentheses
 			b.WriteByte('X')
-			b.WriteString(entry.Ty)
+			b.WriteString(entry.Ty[1:])
 			b.WriteByte('Y')
 		} else {
 			b.WriteString(entry.Ty)
@@ -395,10 +795,24 @@ func (l CompositeLayout) Ident() string {
 	return b.String()
 }
 
+func (l CompositeLayout) Refs() (refs []string) {
+	for _, entry := range l {
+		if len(entry.Ty) > 1 {
+			refs = append(refs, entry.Ty)
+		}
+	}
+	return
+}
+
 func (l CompositeLayout) GenType(c *Compiler) {
 	c.r.typeW.WriteString("type ")
 	c.r.typeW.WriteString(l.Ident())
-	c.r.typeW.WriteString(" = { ")
+	c.r.typeW.WriteString(" = ")
+	l.genFields(c)
+	c.r.typeW.WriteString("\n")
+}
+func (l CompositeLayout) genFields(c *Compiler) {
+	c.r.typeW.WriteString("{ ")
 	for i, entry := range l {
 		if i > 0 {
 			c.r.typeW.WriteString(", ")
@@ -408,7 +822,39 @@ func (l CompositeLayout) GenType(c *Compiler) {
 			fmt.Fprintf(&c.r.typeW, " %d", entry.N)
 		}
 	}
-	c.r.typeW.WriteString(" }\n")
+	c.r.typeW.WriteString(" }")
+}
+
+// UnionLayout is the layout of a QBE union type, which overlays each of its members
+type UnionLayout []CompositeLayout
+
+func (l UnionLayout) Ident() string {
+	b := &strings.Builder{}
+	b.WriteString(":U")
+	for _, member := range l {
+		b.WriteByte('X')
+		b.WriteString(member.Ident()[1:])
+		b.WriteByte('Y')
+	}
+	return b.String()
+}
+
+func (l UnionLayout) Refs() (refs []string) {
+	for _, member := range l {
+		refs = append(refs, member.Refs()...)
+	}
+	return
+}
+
+func (l UnionLayout) GenType(c *Compiler) {
+	c.r.typeW.WriteString("type ")
+	c.r.typeW.WriteString(l.Ident())
+	c.r.typeW.WriteString(" = { ")
+	for _, member := range l {
+		member.genFields(c)
+		c.r.typeW.WriteByte(' ')
+	}
+	c.r.typeW.WriteString("}\n")
 }
 
 type Operand interface {
@@ -428,6 +874,9 @@ type Loop struct {
 	Start, End Block
 }
 
+// Scope maps the names of local variables declared in a block to their locations
+type Scope map[string]Variable
+
 type Temporary uint
 
 func (t Temporary) IsZero() bool {
@@ -458,6 +907,25 @@ func (i IRInteger) Operand() string {
 	return string(i)
 }
 
+type IRFloat struct {
+	Ty byte // 's' or 'd'
+	V  string
+}
+
+func (f IRFloat) Operand() string {
+	return string(f.Ty) + "_" + f.V
+}
+
+type IRData struct {
+	Loc   Global
+	Ty    ConcreteType
+	Items []IRDataItem // Zero-initialized if nil
+}
+type IRDataItem struct {
+	Ty string // Extended type of the item, or "z" for zero bytes
+	V  string
+}
+
 type IRString string
 
 func (s IRString) String() string {
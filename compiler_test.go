@@ -7,6 +7,9 @@ import (
 )
 
 func testCompile(t *testing.T, code, ir string) {
+	testCompileWith(t, NewCompiler(), code, ir)
+}
+func testCompileWith(t *testing.T, c *Compiler, code, ir string) {
 	toks := make(chan Token)
 	go Tokenize(code, toks)
 
@@ -24,7 +27,6 @@ func testCompile(t *testing.T, code, ir string) {
 	prog := p.parseProgram()
 
 	phase = "Compile"
-	c := NewCompiler()
 	c.compile(prog)
 
 	gen := c.r.String()
@@ -34,6 +36,9 @@ func testCompile(t *testing.T, code, ir string) {
 }
 
 func testCompileFailure(t *testing.T, err, code string) {
+	testCompileFailureWith(t, NewCompiler(), err, code)
+}
+func testCompileFailureWith(t *testing.T, c *Compiler, err, code string) {
 	toks := make(chan Token)
 	go Tokenize(code, toks)
 
@@ -52,7 +57,7 @@ func testCompileFailure(t *testing.T, err, code string) {
 			panic(e)
 		}
 	}()
-	NewCompiler().compile(prog)
+	c.compile(prog)
 }
 
 func testMainCompile(t *testing.T, code, ir string) {
@@ -99,6 +104,95 @@ func TestRecursiveFunction(t *testing.T) {
 	`)
 }
 
+func TestMutualRecursion(t *testing.T) {
+	testCompile(t, `
+		fn even(n U32) Bool {
+			if n == 0 {
+				return 1
+			}
+			return odd(n - 1)
+		}
+		fn odd(n U32) Bool {
+			if n == 0 {
+				return 0
+			}
+			return even(n - 1)
+		}
+	`, `
+		function w $even(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =w loadw %t2
+			%t4 =w ceqw %t3, 0
+			jnz %t4, @b1, @b2
+		@b1
+			ret 1
+		@b2
+		@b3
+			%t5 =w loadw %t2
+			%t6 =w sub %t5, 1
+			%t7 =w call $odd(w %t6)
+			%t8 =w extub %t7
+			ret %t8
+		}
+		function w $odd(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =w loadw %t2
+			%t4 =w ceqw %t3, 0
+			jnz %t4, @b1, @b2
+		@b1
+			ret 0
+		@b2
+		@b3
+			%t5 =w loadw %t2
+			%t6 =w sub %t5, 1
+			%t7 =w call $even(w %t6)
+			%t8 =w extub %t7
+			ret %t8
+		}
+	`)
+}
+
+func TestDeclarationOrder(t *testing.T) {
+	testCompile(t, `
+		pub fn main() I32 {
+			var p util.Pair
+			p.a = util.n
+			return util.get(p)
+		}
+		ns util {
+			fn get(p Pair) I32 {
+				return p.a
+			}
+			type Pair struct { a, b Int }
+			type Int = I32
+			var n Int = 3
+		}
+	`, `
+		type :w2 = { w 2 }
+		export function w $main() {
+		@start
+			%t1 =l alloc4 8
+			storew 0, %t1
+			%t2 =l add %t1, 4
+			storew 0, %t2
+			%t3 =w loadw $util.n
+			storew %t3, %t1
+			%t4 =w call $util.get(:w2 %t1)
+			ret %t4
+		}
+		function w $util.get(:w2 %t1) {
+		@start
+			%t2 =w loadw %t1
+			ret %t2
+		}
+		data $util.n = align 4 { w 3 }
+	`)
+}
+
 func TestVariadicFunction(t *testing.T) {
 	testCompile(t, `
 		variadic fn foo(a I32)
@@ -171,6 +265,239 @@ func TestScope(t *testing.T) {
 	`)
 }
 
+func TestCompositeLiteral(t *testing.T) {
+	testCompile(t, `
+		type Point struct { x, y I32 }
+		var origin = Point{y: 3}
+		var tri = [I32 3]{1, 2}
+		fn dist(p Point) I32 {
+			return p.x + p.y
+		}
+		fn mk(x I32) Point {
+			return Point{x: x}
+		}
+		fn f() I32 {
+			var p = Point{1, 2}
+			p = Point{y: 5, x: 4}
+			return dist(Point{y: 7})
+		}
+	`, `
+		type :w2 = { w 2 }
+		function w $dist(:w2 %t1) {
+		@start
+			%t2 =w loadw %t1
+			%t3 =l add %t1, 4
+			%t4 =w loadw %t3
+			%t5 =w add %t2, %t4
+			ret %t5
+		}
+		function :w2 $mk(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =l alloc4 8
+			%t4 =w loadw %t2
+			storew %t4, %t3
+			%t5 =l add %t3, 4
+			storew 0, %t5
+			ret %t3
+		}
+		function w $f() {
+		@start
+			%t1 =l alloc4 8
+			storew 1, %t1
+			%t2 =l add %t1, 4
+			storew 2, %t2
+			%t3 =l alloc4 8
+			%t4 =w loadw %t1
+			storew %t4, %t3
+			%t5 =l add %t3, 4
+			%t6 =l add %t1, 4
+			%t7 =w loadw %t6
+			storew %t7, %t5
+
+			%t8 =l alloc4 8
+			storew 4, %t8
+			%t9 =l add %t8, 4
+			storew 5, %t9
+			%t10 =w loadw %t8
+			storew %t10, %t3
+			%t11 =l add %t3, 4
+			%t12 =l add %t8, 4
+			%t13 =w loadw %t12
+			storew %t13, %t11
+
+			%t14 =l alloc4 8
+			storew 0, %t14
+			%t15 =l add %t14, 4
+			storew 7, %t15
+			%t16 =w call $dist(:w2 %t14)
+			ret %t16
+		}
+		data $origin = align 4 { z 4, w 3 }
+		data $tri = align 4 { w 1, w 2, z 4 }
+	`)
+
+	testCompileFailure(t, "No such field: z", `
+		type Point struct { x, y I32 }
+		fn f() {
+			var p = Point{z: 1}
+		}
+	`)
+	testCompileFailure(t, "Duplicate field y in initializer", `
+		type Point struct { x, y I32 }
+		fn f() {
+			var p = Point{y: 1, x: 2, 3}
+		}
+	`)
+	testCompileFailure(t, "Field names used in initializer for non-struct type [I32 2]", `
+		fn f() {
+			var a = [I32 2]{x: 1}
+		}
+	`)
+	testCompileFailure(t, "Too many values in initializer for [I32 2]", `
+		var a = [I32 2]{1, 2, 3}
+	`)
+	testCompileFailure(t, "Type error in initializer: Point is not Vec", `
+		type Point struct { x, y I32 }
+		type Vec struct { x, y I32 }
+		var v Vec = Point{}
+	`)
+}
+
+func TestSizeof(t *testing.T) {
+	testCompile(t, `
+		extern fn malloc(n U64) []
+		type Node struct { tag U8; next [Node]; val I16 }
+		var buf [U8 sizeof(Node) * 2]
+		const off = offsetof(Node, val)
+		fn f(n U64) [Node] {
+			return malloc(n * sizeof(Node) + alignof(Node) + off)
+		}
+	`, `
+		function l $f(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			%t4 =l mul %t3, 24
+			%t5 =l add %t4, 8
+			%t6 =l add %t5, 16
+			%t7 =l call $malloc(l %t6)
+			ret %t7
+		}
+		data $buf = align 1 { z 48 }
+	`)
+	// Unions are aligned like their most aligned member, and padded to a multiple of that alignment
+	testCompile(t, `
+		type U union { a [U8 9]; b U32 }
+		type S struct { c I8; u U; d I16 }
+		var sizes [U64 5] = {sizeof(U), alignof(U), offsetof(S, u), offsetof(S, d), sizeof(S)}
+	`, `
+		data $sizes = align 8 { l 12, l 4, l 4, l 16, l 20 }
+	`)
+	testCompileFailure(t, "No such field: value", `
+		type Node struct { tag U8; val I16 }
+		const off = offsetof(Node, value)
+	`)
+	testCompileFailure(t, "offsetof used on non-composite type I32", `
+		const off = offsetof(I32, x)
+	`)
+}
+
+func TestBlockScope(t *testing.T) {
+	testMainCompile(t, `
+		var a I32 = 1
+		if a {
+			var a I64 = 2
+			var b I32
+		} else {
+			var b I32
+		}
+		{
+			var a I8 = 3
+			a = 4
+		}
+		a = 5
+	`, `
+		%t1 =l alloc4 4
+		storew 1, %t1
+		%t2 =w loadw %t1
+		jnz %t2, @b1, @b2
+	@b1
+		%t3 =l alloc8 8
+		storel 2, %t3
+		%t4 =l alloc4 4
+		storew 0, %t4
+		jmp @b3
+	@b2
+		%t5 =l alloc4 4
+		storew 0, %t5
+	@b3
+		%t6 =l alloc4 1
+		storeb 3, %t6
+		storeb 4, %t6
+		storew 5, %t1
+	`)
+	testMainCompile(t, `
+		for var i I32 = 0; i < 2; i++ {
+			var c I32
+		}
+		for var i I32 = 0; i < 2; i++ {
+			var c I32
+		}
+	`, `
+		%t1 =l alloc4 4
+		storew 0, %t1
+	@b1
+		%t2 =w loadw %t1
+		%t3 =w csltw %t2, 2
+		jnz %t3, @b2, @b3
+	@b2
+		%t4 =l alloc4 4
+		storew 0, %t4
+		%t5 =w loadw %t1
+		%t6 =w add %t5, 1
+		storew %t6, %t1
+		jmp @b1
+	@b3
+		%t7 =l alloc4 4
+		storew 0, %t7
+	@b4
+		%t8 =w loadw %t7
+		%t9 =w csltw %t8, 2
+		jnz %t9, @b5, @b6
+	@b5
+		%t10 =l alloc4 4
+		storew 0, %t10
+		%t11 =w loadw %t7
+		%t12 =w add %t11, 1
+		storew %t12, %t7
+		jmp @b4
+	@b6
+	`)
+	testCompileFailure(t, "Undefined variable: b", `
+		fn f() {
+			if 1 {
+				var b I32
+			}
+			b = 1
+		}
+	`)
+	testCompileFailure(t, "Undefined variable: i", `
+		fn f() {
+			for var i I32; i < 2; i++ {}
+			i = 1
+		}
+	`)
+	testCompileFailure(t, "Variable already exists", `
+		fn f() {
+			var a I32
+			var a I32
+		}
+	`)
+}
+
 func TestReturn0(t *testing.T) {
 	testMainCompile(t, "", "")
 }
@@ -188,6 +515,41 @@ func TestReturnVoid(t *testing.T) {
 	`)
 }
 
+func TestReturnType(t *testing.T) {
+	testCompileFailure(t, "Type error in return: float literal is not I32", `
+		fn f() I32 {
+			return 1.5
+		}
+	`)
+	testCompileFailure(t, "Type error in return: I64 is not I32", `
+		fn f(x I64) I32 {
+			return x
+		}
+	`)
+	testCompileFailure(t, "Type error in return: Point is not Vec", `
+		type Point struct { x, y I32 }
+		type Vec struct { x, y I32 }
+		fn f(p Point) Vec {
+			return p
+		}
+	`)
+	testCompileFailure(t, "Type error in return: [const I8] is not [I8]", `
+		fn f() [I8] {
+			return "hi"
+		}
+	`)
+	testCompileFailure(t, "Return value in function with no return type: 1", `
+		fn f() {
+			return 1
+		}
+	`)
+	testCompileFailure(t, "Missing return value in function returning I32", `
+		fn f() I32 {
+			return
+		}
+	`)
+}
+
 func TestPrefixExpr(t *testing.T) {
 	testMainCompile(t, `
 		_ = !3
@@ -195,7 +557,7 @@ func TestPrefixExpr(t *testing.T) {
 		_ = -(3)
 		_ = +(3)
 	`, `
-		%t1 =l ceql 0, 3
+		%t1 =w ceql 0, 3
 		%t2 =l xor -1, 3
 		%t3 =l sub 0, 3
 		%t4 =l copy 3
@@ -286,12 +648,12 @@ func TestComparison(t *testing.T) {
 		_ = 4 == i
 		_ = i == 2
 	`, `
-		%t1 =l ceql 4, 2
-		%t2 =l cnel 4, 2
-		%t3 =l csltl 4, 2
-		%t4 =l csgtl 4, 2
-		%t5 =l cslel 4, 2
-		%t6 =l csgel 4, 2
+		%t1 =w ceql 4, 2
+		%t2 =w cnel 4, 2
+		%t3 =w csltl 4, 2
+		%t4 =w csgtl 4, 2
+		%t5 =w cslel 4, 2
+		%t6 =w csgel 4, 2
 
 		%t7 =l alloc4 4
 		storew 0, %t7
@@ -307,16 +669,20 @@ func TestBoolean(t *testing.T) {
 		_ = 4 && 2
 		_ = 4 || 2
 	`, `
-		%t1 =l copy 4
-		jnz %t1, @b1, @b2
+		%t1 =w cnel 4, 0
+		%t2 =w copy %t1
+		jnz %t2, @b1, @b2
 	@b1
-		%t1 =l copy 2
+		%t3 =w cnel 2, 0
+		%t2 =w copy %t3
 	@b2
 
-		%t2 =l copy 4
-		jnz %t2, @b4, @b3
+		%t4 =w cnel 4, 0
+		%t5 =w copy %t4
+		jnz %t5, @b4, @b3
 	@b3
-		%t2 =l copy 2
+		%t6 =w cnel 2, 0
+		%t5 =w copy %t6
 	@b4
 	`)
 }
@@ -342,6 +708,351 @@ func TestCast(t *testing.T) {
 	`)
 }
 
+func TestIntConversion(t *testing.T) {
+	testCompile(t, `
+		fn f(x I64, i I32, p [I16], s I8) Bool {
+			p = i + p
+			var u = cast(x, U8)
+			var w = cast(s, U16)
+			return cast(u, I8) == s && cast(x, Bool) && cast(300, U8) == 44
+		}
+	`, `
+		function w $f(l %t1, w %t2, l %t3, w %t4) {
+		@start
+			%t5 =l alloc8 8
+			storel %t1, %t5
+			%t6 =l alloc4 4
+			storew %t2, %t6
+			%t7 =l alloc8 8
+			storel %t3, %t7
+			%t8 =l alloc4 1
+			storeb %t4, %t8
+			%t9 =w loadw %t6
+			%t10 =l loadl %t7
+			%t11 =l extsw %t9
+			%t12 =l mul 2, %t11
+			%t13 =l add %t12, %t10
+			storel %t13, %t7
+			%t14 =l loadl %t5
+			%t15 =w extub %t14
+			%t16 =l alloc4 1
+			storeb %t15, %t16
+			%t17 =w loadsb %t8
+			%t18 =w extuh %t17
+			%t19 =l alloc4 2
+			storeh %t18, %t19
+			%t20 =w loadub %t16
+			%t21 =w extsb %t20
+			%t22 =w loadsb %t8
+			%t23 =w ceqw %t21, %t22
+			%t24 =w copy %t23
+			jnz %t24, @b1, @b2
+		@b1
+			%t25 =l loadl %t5
+			%t26 =w cnel %t25, 0
+			%t24 =w copy %t26
+		@b2
+			%t27 =w copy %t24
+			jnz %t27, @b3, @b4
+		@b3
+			%t28 =w ceqw 44, 44
+			%t27 =w copy %t28
+		@b4
+			ret %t27
+		}
+	`)
+	// Narrow arithmetic and narrow call results are re-extended, so widening sees the wrapped value
+	testCompile(t, `
+		fn cNeg() I8
+		fn f(a, b U8, n I8, i I32) I64 {
+			var sum = a + b
+			n = -n
+			var k = cNeg()
+			return cast(sum < a, I64) + cast(n, I64) + cast(k, I64) + cast(a, I64) + cast(i, I64)
+		}
+	`, `
+		function l $f(w %t1, w %t2, w %t3, w %t4) {
+		@start
+			%t5 =l alloc4 1
+			storeb %t1, %t5
+			%t6 =l alloc4 1
+			storeb %t2, %t6
+			%t7 =l alloc4 1
+			storeb %t3, %t7
+			%t8 =l alloc4 4
+			storew %t4, %t8
+			%t9 =w loadub %t5
+			%t10 =w loadub %t6
+			%t11 =w add %t9, %t10
+			%t12 =w extub %t11
+			%t13 =l alloc4 1
+			storeb %t12, %t13
+			%t14 =w loadsb %t7
+			%t15 =w sub 0, %t14
+			%t16 =w extsb %t15
+			storeb %t16, %t7
+			%t17 =w call $cNeg()
+			%t18 =w extsb %t17
+			%t19 =l alloc4 1
+			storeb %t18, %t19
+			%t20 =w loadub %t13
+			%t21 =w loadub %t5
+			%t22 =w cultw %t20, %t21
+			%t23 =l extub %t22
+			%t24 =w loadsb %t7
+			%t25 =l extsb %t24
+			%t26 =l add %t23, %t25
+			%t27 =w loadsb %t19
+			%t28 =l extsb %t27
+			%t29 =l add %t26, %t28
+			%t30 =w loadub %t5
+			%t31 =l extub %t30
+			%t32 =l add %t29, %t31
+			%t33 =w loadw %t8
+			%t34 =l extsw %t33
+			%t35 =l add %t32, %t34
+			ret %t35
+		}
+	`)
+}
+
+func TestPointerCast(t *testing.T) {
+	testCompile(t, `
+		type Header struct { size, cap U64 }
+		fn g(x I32) I32
+		fn f(buf [U8], d F64, s I8) U64 {
+			var h = cast(buf, [Header])
+			var p = cast((cast(buf, U64) + 7) & ^7, [U8])
+			var fp = cast(g, [])
+			var f = cast(fp, fn(I32) I32)
+			var u = bitcast(s, U8)
+			var bits = bitcast(d, U64)
+			var one = bitcast(1.0, I64) + cast(bitcast(1.5f32, I32), I64)
+			return bits + bitcast(bitcast(bits, F64), U64)
+		}
+	`, `
+		function l $f(l %t1, d %t2, w %t3) {
+		@start
+			%t4 =l alloc8 8
+			storel %t1, %t4
+			%t5 =l alloc8 8
+			stored %t2, %t5
+			%t6 =l alloc4 1
+			storeb %t3, %t6
+			%t7 =l loadl %t4
+			%t8 =l alloc8 8
+			storel %t7, %t8
+			%t9 =l loadl %t4
+			%t10 =l add %t9, 7
+			%t11 =l xor -1, 7
+			%t12 =l and %t10, %t11
+			%t13 =l alloc8 8
+			storel %t12, %t13
+			%t14 =l alloc8 8
+			storel $g, %t14
+			%t15 =l loadl %t14
+			%t16 =l alloc8 8
+			storel %t15, %t16
+			%t17 =w loadsb %t6
+			%t18 =w extub %t17
+			%t19 =l alloc4 1
+			storeb %t18, %t19
+			%t20 =d loadd %t5
+			%t21 =l cast %t20
+			%t22 =l alloc8 8
+			storel %t21, %t22
+			%t23 =l add 4607182418800017408, 1069547520
+			%t24 =l alloc8 8
+			storel %t23, %t24
+			%t25 =l loadl %t22
+			%t26 =l loadl %t22
+			%t27 =d cast %t26
+			%t28 =l cast %t27
+			%t29 =l add %t25, %t28
+			ret %t29
+		}
+	`)
+	testCompileFailure(t, "Cannot cast [U8] to I32: pointers can only be cast to pointers, I64 or U64", `
+		fn f(p [U8]) I32 {
+			return cast(p, I32)
+		}
+	`)
+	testCompileFailure(t, "Cannot cast F64 to [U8]: pointers can only be cast to pointers, I64 or U64", `
+		fn f(x F64) [U8] {
+			return cast(x, [U8])
+		}
+	`)
+	testCompileFailure(t, "Cannot bitcast F64 to U32: sizes differ", `
+		fn f(x F64) U32 {
+			return bitcast(x, U32)
+		}
+	`)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	testMainCompile(t, `
+		var a, b F64
+		a = 1.5
+		b = a*2 + a/b
+		b -= 1
+		a = -a
+
+		var f F32
+		f = 2.5
+		f += 1
+	`, `
+		%t1 =l alloc8 8
+		stored 0, %t1
+		%t2 =l alloc8 8
+		stored 0, %t2
+
+		stored d_1.5, %t1
+
+		%t3 =d loadd %t1
+		%t4 =d mul %t3, d_2
+		%t5 =d loadd %t1
+		%t6 =d loadd %t2
+		%t7 =d div %t5, %t6
+		%t8 =d add %t4, %t7
+		stored %t8, %t2
+
+		%t9 =d loadd %t2
+		%t10 =d sub %t9, d_1
+		stored %t10, %t2
+
+		%t11 =d loadd %t1
+		%t12 =d neg %t11
+		stored %t12, %t1
+
+		%t13 =l alloc4 4
+		stores 0, %t13
+		stores s_2.5, %t13
+
+		%t14 =s loads %t13
+		%t15 =s add %t14, s_1
+		stores %t15, %t13
+	`)
+
+	testCompileFailure(t, "Operator % is not defined for floating-point type F64", `
+		fn f(x F64) {
+			_ = x % 2
+		}
+	`)
+	testCompileFailure(t, "Operator ^ is not defined for floating-point type F32", `
+		fn f(x F32) {
+			_ = ^x
+		}
+	`)
+}
+
+func TestFloatComparison(t *testing.T) {
+	testMainCompile(t, `
+		var a F64
+		var b F32
+		_ = a < 1.5
+		_ = b >= 2
+		_ = a == a
+	`, `
+		%t1 =l alloc8 8
+		stored 0, %t1
+		%t2 =l alloc4 4
+		stores 0, %t2
+
+		%t3 =d loadd %t1
+		%t4 =w cltd %t3, d_1.5
+		%t5 =s loads %t2
+		%t6 =w cges %t5, s_2
+		%t7 =d loadd %t1
+		%t8 =d loadd %t1
+		%t9 =w ceqd %t7, %t8
+	`)
+}
+
+func TestFloatCast(t *testing.T) {
+	testMainCompile(t, `
+		var d F64
+		var s F32
+		var i I32
+		var u U64
+		d = cast(s, F64)
+		s = cast(d, F32)
+		d = cast(i, F64)
+		s = cast(u, F32)
+		i = cast(d, I32)
+		u = cast(s, U64)
+		d = cast(1, F64)
+	`, `
+		%t1 =l alloc8 8
+		stored 0, %t1
+		%t2 =l alloc4 4
+		stores 0, %t2
+		%t3 =l alloc4 4
+		storew 0, %t3
+		%t4 =l alloc8 8
+		storel 0, %t4
+
+		%t5 =s loads %t2
+		%t6 =d exts %t5
+		stored %t6, %t1
+
+		%t7 =d loadd %t1
+		%t8 =s truncd %t7
+		stores %t8, %t2
+
+		%t9 =w loadw %t3
+		%t10 =d swtof %t9
+		stored %t10, %t1
+
+		%t11 =l loadl %t4
+		%t12 =s ultof %t11
+		stores %t12, %t2
+
+		%t13 =d loadd %t1
+		%t14 =w dtosi %t13
+		storew %t14, %t3
+
+		%t15 =s loads %t2
+		%t16 =l stoui %t15
+		storel %t16, %t4
+
+		stored d_1, %t1
+	`)
+}
+
+func TestFloatCall(t *testing.T) {
+	testCompile(t, `
+		variadic fn printf(fmt [const I8]) I32
+		fn half(x F32) F32 {
+			return x / 2
+		}
+		fn f() {
+			var s F32
+			_ = half(1)
+			_ = printf("%f", s)
+		}
+	`, `
+		function s $half(s %t1) {
+		@start
+			%t2 =l alloc4 4
+			stores %t1, %t2
+			%t3 =s loads %t2
+			%t4 =s div %t3, s_2
+			ret %t4
+		}
+		function $f() {
+		@start
+			%t1 =l alloc4 4
+			stores 0, %t1
+			%t2 =s call $half(s s_1)
+			%t3 =s loads %t1
+			%t4 =d exts %t3
+			%t5 =w call $printf(l $str0, d %t4, ...)
+			ret
+		}
+		data $str0 = { b "%f", b 0 }
+	`)
+}
+
 func TestNestedArithmetic(t *testing.T) {
 	testMainCompile(t, `_ = (1 + 10*2) * 2`, `
 		%t1 =l mul 10, 2
@@ -372,13 +1083,112 @@ func TestLocalVariables(t *testing.T) {
 	`)
 }
 
-func TestGlobalVariables(t *testing.T) {
-	testCompile(t, `
-		extern var foo I32
-		var bar I32
-		pub fn main() I32 {
-			return foo + bar
-		}
+func TestLocalInit(t *testing.T) {
+	testMainCompile(t, `
+		var i, j I32 = 7, i
+		var k = 2.5
+		var l U8 = 'a'
+		var m = &l
+	`, `
+		%t1 =l alloc4 4
+		storew 7, %t1
+		%t2 =w loadw %t1
+		%t3 =l alloc4 4
+		storew %t2, %t3
+
+		%t4 =l alloc8 8
+		stored d_2.5, %t4
+		%t5 =l alloc4 1
+		storeb 97, %t5
+		%t6 =l alloc8 8
+		storel %t5, %t6
+	`)
+	testCompileFailure(t, "Type error in initializer: I64 is not I32", `
+		fn f() {
+			var i I64
+			var j I32 = i
+		}
+	`)
+	testCompileFailure(t, "Undefined variable: i", `
+		fn f() {
+			var i = i
+		}
+	`)
+	testCompileFailure(t, "Initializer list used without a type", `
+		fn f() {
+			var i = {1}
+		}
+	`)
+}
+
+func TestLocalInitComposite(t *testing.T) {
+	testMainCompile(t, `
+		var p struct { x I8; y I32 } = {1}
+		var a [I16 3] = {4, 5}
+		var b = a
+		var c [I16 3] = a
+	`, `
+		%t1 =l alloc4 8
+		storeb 1, %t1
+		%t2 =l add %t1, 4
+		storew 0, %t2
+
+		%t3 =l alloc4 6
+		storeh 4, %t3
+		%t4 =l add %t3, 2
+		storeh 5, %t4
+		%t5 =l add %t3, 4
+		storeh 0, %t5
+
+		%t6 =l alloc8 8
+		storel %t3, %t6
+
+		%t7 =l alloc4 6
+		%t8 =w loadsh %t3
+		storeh %t8, %t7
+		%t9 =l add %t7, 2
+		%t10 =l add %t3, 2
+		%t11 =w loadsh %t10
+		storeh %t11, %t9
+		%t12 =l add %t7, 4
+		%t13 =l add %t3, 4
+		%t14 =w loadsh %t13
+		storeh %t14, %t12
+	`)
+
+	// The initializer refers to the outer variable, not the one being declared
+	testCompile(t, `
+		type P struct { a, b I32 }
+		fn f(p P) I32 {
+			{
+				var p P = {p.b, p.a}
+				return p.a
+			}
+		}
+	`, `
+		type :w2 = { w 2 }
+		function w $f(:w2 %t1) {
+		@start
+			%t2 =l alloc4 8
+			%t3 =l add %t1, 4
+			%t4 =w loadw %t3
+			storew %t4, %t2
+			%t5 =l add %t2, 4
+			%t6 =w loadw %t1
+			storew %t6, %t5
+			%t7 =w loadw %t2
+			ret %t7
+		}
+	`)
+}
+
+func TestGlobalVariables(t *testing.T) {
+	testCompile(t, `
+		extern var foo I32
+		var bar I32
+		pub fn main() I32 {
+			return foo + bar
+		}
 	`, `
 		export function w $main() {
 		@start
@@ -391,6 +1201,161 @@ func TestGlobalVariables(t *testing.T) {
 	`)
 }
 
+func TestGlobalInit(t *testing.T) {
+	testCompile(t, `
+		var a I32 = 42
+		var b, c U8 = 1 + 2, 300 - 45
+		var d F32 = 2.5
+		var e F64 = -1 / 4.0
+		var f I8 = cast(255, I8) << 4
+		var g [const I8] = "hi"
+		var h [I32] = &a
+	`, `
+		data $str0 = { b "hi", b 0 }
+		data $a = align 4 { w 42 }
+		data $b = align 1 { b 3 }
+		data $c = align 1 { b 255 }
+		data $d = align 4 { s s_2.5 }
+		data $e = align 8 { d d_-0.25 }
+		data $f = align 1 { b -16 }
+		data $g = align 8 { l $str0 }
+		data $h = align 8 { l $a }
+	`)
+}
+
+func TestGlobalInitComposite(t *testing.T) {
+	testCompile(t, `
+		type Point struct { x, y I16 }
+		type Thing struct {
+			a U8
+			p Point
+			n [I32 3]
+			q [Point]
+		}
+		var pt Point = {1, 2}
+		var th Thing = {7, {3, 4}, {5}, &pt}
+		var arr [Point 3] = {{1, 2}, {}}
+		var y [I16] = &th.p.y
+		var z [I32] = th.n
+		var u union { a I8; b I64 } = {-1}
+		const last = 2
+		var e [I32] = &th.n[last]
+		var f [I16] = &arr[1].y
+		var g [Point] = &arr[0 + 2]
+	`, `
+		data $pt = align 2 { h 1, h 2 }
+		data $th = align 8 { b 7, z 1, h 3, h 4, z 2, w 5, z 8, z 4, l $pt }
+		data $arr = align 2 { h 1, h 2, z 4, z 4 }
+		data $y = align 8 { l $th + 4 }
+		data $z = align 8 { l $th + 8 }
+		data $u = align 8 { b -1, z 7 }
+		data $e = align 8 { l $th + 16 }
+		data $f = align 8 { l $arr + 6 }
+		data $g = align 8 { l $arr + 8 }
+	`)
+}
+
+func TestGlobalInitError(t *testing.T) {
+	testCompileFailure(t, "Cannot initialize extern variable", `
+		extern var a I32 = 1
+	`)
+	testCompileFailure(t, "Wrong number of initializers in variable declaration", `
+		var a, b I32 = 1
+	`)
+	testCompileFailure(t, "Type error in initializer: float literal is not I32", `
+		var a I32 = 1.5
+	`)
+	testCompileFailure(t, "Initializer is not constant: a", `
+		var a I32
+		var b I32 = a
+	`)
+	testCompileFailure(t, "Index 3 out of range", `
+		var a [I32 3]
+		var p [I32] = &a[3]
+	`)
+	testCompileFailure(t, "Initializer is not constant: &a[i]", `
+		var a [I32 3]
+		var i I32
+		var p [I32] = &a[i]
+	`)
+	testCompileFailure(t, "Initializer is not constant: &q[1]", `
+		var a [I32 3]
+		var q [I32] = a
+		var p [I32] = &q[1]
+	`)
+	testCompileFailure(t, "Too many values in initializer for [I32 2]", `
+		var a [I32 2] = {1, 2, 3}
+	`)
+	testCompileFailure(t, "Initializer for [I32 2] must be an initializer list", `
+		var a [I32 2] = 1
+	`)
+	testCompileFailure(t, "Division by zero in constant expression", `
+		var a I32 = 1 / 0
+	`)
+}
+
+func TestConst(t *testing.T) {
+	testCompile(t, `
+		type Buf [I16 size.n * 2]
+		ns size {
+			const n = m + 1
+			const m I32 = 1 << 2 | 1
+		}
+		const pi F32 = 3.25
+		var buf Buf
+		var k I32 = -size.m
+		fn f(x I32) F32 {
+			const two = 2
+			x = x * two + size.n
+			var y = x + size.m
+			return pi
+		}
+	`, `
+		function s $f(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =w loadw %t2
+			%t4 =w mul %t3, 2
+			%t5 =w add %t4, 6
+			storew %t5, %t2
+			%t6 =w loadw %t2
+			%t7 =w add %t6, 5
+			%t8 =l alloc4 4
+			storew %t7, %t8
+			ret s_3.25
+		}
+		data $buf = align 2 { z 24 }
+		data $k = align 4 { w -5 }
+	`)
+	testCompileFailure(t, "Cannot assign to constant n", `
+		const n I32 = 1
+		fn f() {
+			n = 2
+		}
+	`)
+	testCompileFailure(t, "Cannot take the address of constant n", `
+		const n I32 = 1
+		fn f() {
+			var p = &n
+		}
+	`)
+	testCompileFailure(t, "Value of constant n is not constant: x", `
+		var x I32
+		const n = x
+	`)
+	testCompileFailure(t, "Constant n refers to itself", `
+		const n = n + 1
+	`)
+	testCompileFailure(t, "Array length must be a constant integer", `
+		var x [I32 1.5]
+	`)
+	testCompileFailure(t, "Variable already exists", `
+		const n = 1
+		var n I32
+	`)
+}
+
 func TestTypeDef(t *testing.T) {
 	testCompile(t, `
 		type Foo I32
@@ -434,6 +1399,63 @@ func TestTypeDef(t *testing.T) {
 	`)
 }
 
+func TestEnum(t *testing.T) {
+	testCompile(t, `
+		type Color enum U8 { red, green, blue = 7, black }
+		ns gfx {
+			type Mode enum I32 {
+				text = -1
+				vga
+			}
+		}
+		var c Color = Color.blue
+		fn f(c Color) gfx.Mode {
+			if c == Color.black {
+				return gfx.Mode.text
+			}
+			c = Color.green
+			return cast(cast(c, U8) + 1, gfx.Mode)
+		}
+	`, `
+		function w $f(w %t1) {
+		@start
+			%t2 =l alloc4 1
+			storeb %t1, %t2
+			%t3 =w loadub %t2
+			%t4 =w ceqw %t3, 8
+			jnz %t4, @b1, @b2
+		@b1
+			ret -1
+		@b2
+		@b3
+			storeb 1, %t2
+			%t5 =w loadub %t2
+			%t6 =w add %t5, 1
+			%t7 =w extub %t6
+			ret %t7
+		}
+		data $c = align 1 { b 7 }
+	`)
+	testCompileFailure(t, "Type error in initializer: Color is not Shade", `
+		type Color enum U8 { red }
+		type Shade enum U8 { red }
+		var c Shade = Color.red
+	`)
+	testCompileFailure(t, "Value of enum member b does not fit in U8", `
+		type Color enum U8 { a = 255, b }
+	`)
+	testCompileFailure(t, "Duplicate enum member a", `
+		type Color enum U8 { a, a }
+	`)
+	testCompileFailure(t, "Underlying type of enum Color must be an integer type", `
+		type Color enum F32 { a }
+	`)
+	testCompileFailure(t, "Undefined variable: Color.white", `
+		type Color enum U8 { red }
+		var c Color = Color.white
+	`)
+}
+
 func TestTypeAlias(t *testing.T) {
 	testCompile(t, `
 		type Foo = I32
@@ -558,13 +1580,13 @@ func TestUnion(t *testing.T) {
 			return 0
 		}
 	`, `
+		type :UXwYXlY = { { w } { l } }
 		type :b = { b }
-		type :l = { l }
 		export function w $main() {
 		@start
 			%t1 =l alloc8 8
 			storel 0, %t1
-			call $fooFn(:l %t1)
+			call $fooFn(:UXwYXlY %t1)
 
 			%t2 =l alloc4 1
 			storeb 0, %t2
@@ -573,6 +1595,77 @@ func TestUnion(t *testing.T) {
 			ret 0
 		}
 	`)
+
+	// Zeroing and initializing a union covers its whole size, even where no member does
+	testCompile(t, `
+		type U union { a I8; b [U8 5]; c U32 }
+		fn f() U {
+			var u U = {1}
+			var v U
+			return u
+		}
+	`, `
+		type :b5 = { b 5 }
+		type :UXbYXXb5YYXwY = { { b } { :b5 } { w } }
+		function :UXbYXXb5YYXwY $f() {
+		@start
+			%t1 =l alloc4 8
+			storew 0, %t1
+			%t2 =l add %t1, 4
+			storew 0, %t2
+			storeb 1, %t1
+			%t3 =l alloc4 8
+			storew 0, %t3
+			%t4 =l add %t3, 4
+			storew 0, %t4
+			ret %t1
+		}
+	`)
+
+	// The alignment of a union comes from its most aligned member, which need not be the largest
+	testCompile(t, `
+		type Wide union { a [U8 9]; b U64 }
+		type Holder struct { c I8; u Wide; d I8 }
+		fn get(h Holder) Holder
+		fn f() {
+			var h Holder
+			h.d = 1
+			h = get(h)
+		}
+	`, `
+		type :b9 = { b 9 }
+		type :UXXb9YYXlY = { { :b9 } { l } }
+		type :bXUXXb9YYXlYYb = { b, :UXXb9YYXlY, b }
+		function $f() {
+		@start
+			%t1 =l alloc8 32
+			storeb 0, %t1
+			%t2 =l add %t1, 8
+			storel 0, %t2
+			%t3 =l add %t2, 8
+			storel 0, %t3
+			%t4 =l add %t1, 24
+			storeb 0, %t4
+			%t5 =l add %t1, 24
+			storeb 1, %t5
+			%t6 =:bXUXXb9YYXlYYb call $get(:bXUXXb9YYXlYYb %t1)
+			%t7 =w loadsb %t6
+			storeb %t7, %t1
+			%t8 =l add %t1, 8
+			%t9 =l add %t6, 8
+			%t10 =l loadl %t9
+			storel %t10, %t8
+			%t11 =l add %t8, 8
+			%t12 =l add %t9, 8
+			%t13 =l loadl %t12
+			storel %t13, %t11
+			%t14 =l add %t1, 24
+			%t15 =l add %t6, 24
+			%t16 =w loadsb %t15
+			storeb %t16, %t14
+			ret
+		}
+	`)
 }
 
 func TestRecursiveType(t *testing.T) {
@@ -587,7 +1680,7 @@ func TestRecursiveType(t *testing.T) {
 		var foo Bar
 		fn f(bar Bar) Foo {
 			foo.foo = bar.foo
-			return bar.foo.foo
+			return [bar.foo.foo]
 		}
 	`, `
 		type :l = { l }
@@ -605,6 +1698,136 @@ func TestRecursiveType(t *testing.T) {
 	`)
 }
 
+func TestMutuallyRecursiveType(t *testing.T) {
+	testCompile(t, `
+		fn f(a A) I32 {
+			return a.b.a.v
+		}
+		type A struct {
+			b [B]
+			v I32
+		}
+		type B struct {
+			a [A]
+			arr [C 2]
+		}
+		type C = struct { x I16 }
+	`, `
+		type :lw = { l, w }
+		function w $f(:lw %t1) {
+		@start
+			%t2 =l loadl %t1
+			%t3 =l loadl %t2
+			%t4 =l add %t3, 8
+			%t5 =w loadw %t4
+			ret %t5
+		}
+	`)
+	testCompileFailure(t, "Type A contains itself", `
+		type A struct { b B }
+		type B [A 2]
+	`)
+	testCompileFailure(t, "Type A refers to itself", `
+		type A = B
+		type B = [A]
+	`)
+}
+
+func TestCompositeAssign(t *testing.T) {
+	testCompile(t, `
+		type Foo struct { a I8; b I64 }
+		type Bar union { a I8; b I32 }
+		fn f(foo Foo, bar Bar) {
+			var foo2 Foo
+			foo2 = foo
+			var bar2 Bar
+			bar2 = bar
+		}
+	`, `
+		type :UXbYXwY = { { b } { w } }
+		type :bl = { b, l }
+		function $f(:bl %t1, :UXbYXwY %t2) {
+		@start
+			%t3 =l alloc8 16
+			storeb 0, %t3
+			%t4 =l add %t3, 8
+			storel 0, %t4
+
+			%t5 =w loadsb %t1
+			storeb %t5, %t3
+			%t6 =l add %t3, 8
+			%t7 =l add %t1, 8
+			%t8 =l loadl %t7
+			storel %t8, %t6
+
+			%t9 =l alloc4 4
+			storew 0, %t9
+
+			%t10 =w loadw %t2
+			storew %t10, %t9
+
+			ret
+		}
+	`)
+
+	testCompile(t, `
+		type Foo struct { a [I16 2] }
+		fn f() {
+			var a, b [I16 2]
+			a = b
+			var foo Foo
+			foo.a = a
+		}
+	`, `
+		function $f() {
+		@start
+			%t1 =l alloc4 4
+			storeh 0, %t1
+			%t2 =l add %t1, 2
+			storeh 0, %t2
+			%t3 =l alloc4 4
+			storeh 0, %t3
+			%t4 =l add %t3, 2
+			storeh 0, %t4
+
+			%t5 =w loadsh %t3
+			storeh %t5, %t1
+			%t6 =l add %t1, 2
+			%t7 =l add %t3, 2
+			%t8 =w loadsh %t7
+			storeh %t8, %t6
+
+			%t9 =l alloc4 4
+			storeh 0, %t9
+			%t10 =l add %t9, 2
+			storeh 0, %t10
+
+			%t11 =w loadsh %t1
+			storeh %t11, %t9
+			%t12 =l add %t9, 2
+			%t13 =l add %t1, 2
+			%t14 =w loadsh %t13
+			storeh %t14, %t12
+
+			ret
+		}
+	`)
+
+	testCompileFailure(t, "Type error in assignment: [I16 3] is not [I16 2]", `
+		fn f() {
+			var a [I16 2]
+			var b [I16 3]
+			a = b
+		}
+	`)
+	testCompileFailure(t, "Operand of binary expression is of non-numeric type Foo", `
+		type Foo struct { a I32 }
+		fn f(a, b Foo) {
+			a += b
+		}
+	`)
+}
+
 func TestCompositeReturn(t *testing.T) {
 	testCompile(t, `
 		type S struct { a I32 }
@@ -634,6 +1857,49 @@ func TestCompositeReturn(t *testing.T) {
 	`)
 }
 
+func TestCompositeCall(t *testing.T) {
+	testCompile(t, `
+		type Inner struct { a, b I32 }
+		type Outer struct { i Inner; c I8 }
+		fn get(i Inner) Outer
+		fn f() {
+			var i Inner
+			var o Outer
+			o = get(i)
+		}
+	`, `
+		type :w2 = { w 2 }
+		type :Xw2Yb = { :w2, b }
+		function $f() {
+		@start
+			%t1 =l alloc4 8
+			storew 0, %t1
+			%t2 =l add %t1, 4
+			storew 0, %t2
+			%t3 =l alloc4 12
+			storew 0, %t3
+			%t4 =l add %t3, 4
+			storew 0, %t4
+			%t5 =l add %t3, 8
+			storeb 0, %t5
+
+			%t6 =:Xw2Yb call $get(:w2 %t1)
+			%t7 =w loadw %t6
+			storew %t7, %t3
+			%t8 =l add %t3, 4
+			%t9 =l add %t6, 4
+			%t10 =w loadw %t9
+			storew %t10, %t8
+			%t11 =l add %t3, 8
+			%t12 =l add %t6, 8
+			%t13 =w loadsb %t12
+			storeb %t13, %t11
+
+			ret
+		}
+	`)
+}
+
 func TestFieldAccess(t *testing.T) {
 	testCompile(t, `
 		type Foo struct { a, b I32; c I64 }
@@ -731,21 +1997,23 @@ func TestSmallTypes(t *testing.T) {
 		%t3 =w loadsh %t1
 		%t4 =w loadsh %t2
 		%t5 =w add %t3, %t4
-		storeh %t5, %t1
+		%t6 =w extsh %t5
+		storeh %t6, %t1
 
 
-		%t6 =l alloc4 1
-		storeb 0, %t6
 		%t7 =l alloc4 1
 		storeb 0, %t7
+		%t8 =l alloc4 1
+		storeb 0, %t8
 
-		storeb 7, %t6
-		storeb 5, %t7
+		storeb 7, %t7
+		storeb 5, %t8
 
-		%t8 =w loadub %t6
 		%t9 =w loadub %t7
-		%t10 =w add %t8, %t9
-		storeb %t10, %t6
+		%t10 =w loadub %t8
+		%t11 =w add %t9, %t10
+		%t12 =w extub %t11
+		storeb %t12, %t7
 	`)
 }
 
@@ -796,16 +2064,19 @@ func TestSmallTypeFunction(t *testing.T) {
 			storeb 0, %t1
 			%t2 =w loadub %t1
 			%t3 =w call $bool(w %t2)
+			%t4 =w extub %t3
 
-			%t4 =l alloc4 1
-			storeb 0, %t4
-			%t5 =w loadsb %t4
-			%t6 =w call $i8(w %t5)
+			%t5 =l alloc4 1
+			storeb 0, %t5
+			%t6 =w loadsb %t5
+			%t7 =w call $i8(w %t6)
+			%t8 =w extsb %t7
 
-			%t7 =l alloc4 2
-			storeh 0, %t7
-			%t8 =w loadsh %t7
-			%t9 =w call $i16(w %t8)
+			%t9 =l alloc4 2
+			storeh 0, %t9
+			%t10 =w loadsh %t9
+			%t11 =w call $i16(w %t10)
+			%t12 =w extsh %t11
 
 			ret
 		}
@@ -914,41 +2185,426 @@ func TestElseIf(t *testing.T) {
 	`)
 }
 
-func TestFor0(t *testing.T) {
-	testMainCompile(t, `
-		var a I32
-		for {a = 0}
-		for ;; {a = 1}
+func TestSwitch(t *testing.T) {
+	testCompile(t, `
+		fn f(x I32) I32 {
+			switch x {
+			case 1, 2:
+				return 10
+			case -3:
+				x = 4
+			default:
+				x = 5
+			}
+			return x
+		}
 	`, `
-		%t1 =l alloc4 4
-		storew 0, %t1
-
-	@b1
-	@b2
-		storew 0, %t1
-		jmp @b1
-	@b3
-
-	@b4
-	@b5
-		storew 1, %t1
-		jmp @b4
-	@b6
+		function w $f(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =w loadw %t2
+			%t4 =w ceqw %t3, -3
+			jnz %t4, @b2, @b5
+		@b5
+			%t5 =w ceqw %t3, 1
+			jnz %t5, @b1, @b6
+		@b6
+			%t6 =w ceqw %t3, 2
+			jnz %t6, @b1, @b3
+		@b1
+			ret 10
+		@b2
+			storew 4, %t2
+			jmp @b4
+		@b3
+			storew 5, %t2
+			jmp @b4
+		@b4
+			%t7 =w loadw %t2
+			ret %t7
+		}
 	`)
 }
 
-func TestFor1(t *testing.T) {
-	testMainCompile(t, `
-		var a I32
-		for 1 {a = 0}
-		for ; 2; {a = 1}
+func TestSwitchTree(t *testing.T) {
+	testCompile(t, `
+		type Op enum U8 { add, sub, mul, div, mod }
+		fn f(op Op) {
+			switch op {
+			case Op.mod, Op.add:
+			case Op.sub:
+			case Op.div:
+			case Op.mul:
+			}
+		}
 	`, `
-		%t1 =l alloc4 4
-		storew 0, %t1
-
-	@b1
-		jnz 1, @b2, @b3
-	@b2
+		function $f(w %t1) {
+		@start
+			%t2 =l alloc4 1
+			storeb %t1, %t2
+			%t3 =w loadub %t2
+			%t4 =w cultw %t3, 2
+			jnz %t4, @b6, @b7
+		@b6
+			%t5 =w ceqw %t3, 0
+			jnz %t5, @b1, @b8
+		@b8
+			%t6 =w ceqw %t3, 1
+			jnz %t6, @b2, @b5
+		@b7
+			%t7 =w ceqw %t3, 2
+			jnz %t7, @b4, @b9
+		@b9
+			%t8 =w ceqw %t3, 3
+			jnz %t8, @b3, @b10
+		@b10
+			%t9 =w ceqw %t3, 4
+			jnz %t9, @b1, @b5
+		@b1
+			jmp @b5
+		@b2
+			jmp @b5
+		@b3
+			jmp @b5
+		@b4
+			jmp @b5
+		@b5
+			ret
+		}
+	`)
+	testCompileFailure(t, "Switch on Op is missing cases: sub, mod", `
+		type Op enum U8 { add, sub, mul, mod }
+		fn f(op Op) {
+			switch op {
+			case Op.add, Op.mul:
+			}
+		}
+	`)
+	testCompileFailure(t, "Duplicate case 1 in switch", `
+		fn f(x I32) {
+			switch x {
+			case 1:
+			case 2, 1:
+			}
+		}
+	`)
+	testCompileFailure(t, "Type error in switch case: integer literal is not Op", `
+		type Op enum U8 { add }
+		fn f(op Op) {
+			switch op {
+			case 0:
+			}
+		}
+	`)
+	testCompileFailure(t, "Case value is not constant: y", `
+		fn f(x, y I32) {
+			switch x {
+			case y:
+			}
+		}
+	`)
+	testCompileFailure(t, "Switch value must be an integer or enum, not F64", `
+		fn f(x F64) {
+			switch x {
+			}
+		}
+	`)
+}
+
+func TestVariant(t *testing.T) {
+	testCompile(t, `
+		type Shape variant {
+			circle F64
+			rect struct { w, h F64 }
+		}
+		fn f(s [Shape], r F64) {
+			s.circle = r
+		}
+	`, `
+		function $f(l %t1, d %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			stored %t2, %t4
+			%t5 =l loadl %t3
+			storew 0, %t5
+			%t6 =l add %t5, 8
+			%t7 =d loadd %t4
+			stored %t7, %t6
+			ret
+		}
+	`)
+	// The payload is aligned for its most aligned member, even if a larger member needs less alignment
+	testCompile(t, `
+		type Key variant { name [U8 12]; id U64 }
+		var size = sizeof(Key)
+		fn f(k [Key], id U64) {
+			k.id = id
+		}
+	`, `
+		function $f(l %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			storel %t2, %t4
+			%t5 =l loadl %t3
+			storew 1, %t5
+			%t6 =l add %t5, 8
+			%t7 =l loadl %t4
+			storel %t7, %t6
+			ret
+		}
+		data $size = align 8 { l 24 }
+	`)
+	testCompileFailure(t, "Variant member s.circle can only be read in a match statement", `
+		type Shape variant { circle F64; square F64 }
+		fn f(s Shape) F64 {
+			return s.circle
+		}
+	`)
+	testCompileFailure(t, "Variant member s.circle can only be read in a match statement", `
+		type Shape variant { circle F64; square F64 }
+		fn f(s Shape) {
+			s.circle += 1.0
+		}
+	`)
+	testCompileFailure(t, "Type Shape contains itself", `
+		type Shape variant { circle F64; group Shape }
+	`)
+}
+
+func TestVariantInit(t *testing.T) {
+	// Initializers name the active member, and fields of the payload are modified through a match
+	testCompile(t, `
+		type Shape variant {
+			circle F64
+			rect struct { w, h F64 }
+		}
+		var unit = Shape{rect: {1.0, 1.0}}
+		var none Shape = {}
+		fn f(p [Shape], r F64) {
+			var s = Shape{circle: r}
+			[p] = Shape{rect: {w: r}}
+			match [p] {
+			case rect(q):
+				q.h = 2.0
+			case circle:
+			}
+		}
+	`, `
+		function $f(l %t1, d %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			stored %t2, %t4
+			%t5 =l alloc8 24
+			storew 0, %t5
+			%t6 =l add %t5, 8
+			storel 0, %t6
+			%t7 =l add %t6, 8
+			storel 0, %t7
+			%t8 =l add %t5, 8
+			%t9 =d loadd %t4
+			stored %t9, %t8
+			%t10 =l alloc8 24
+			%t11 =w loadw %t5
+			storew %t11, %t10
+			%t12 =l add %t10, 8
+			%t13 =l add %t5, 8
+			%t14 =l loadl %t13
+			storel %t14, %t12
+			%t15 =l add %t12, 8
+			%t16 =l add %t13, 8
+			%t17 =l loadl %t16
+			storel %t17, %t15
+			%t18 =l loadl %t3
+			%t19 =l alloc8 24
+			storew 1, %t19
+			%t20 =l add %t19, 8
+			storel 0, %t20
+			%t21 =l add %t20, 8
+			storel 0, %t21
+			%t22 =l add %t19, 8
+			%t23 =d loadd %t4
+			stored %t23, %t22
+			%t24 =l add %t22, 8
+			stored 0, %t24
+			%t25 =w loadw %t19
+			storew %t25, %t18
+			%t26 =l add %t18, 8
+			%t27 =l add %t19, 8
+			%t28 =l loadl %t27
+			storel %t28, %t26
+			%t29 =l add %t26, 8
+			%t30 =l add %t27, 8
+			%t31 =l loadl %t30
+			storel %t31, %t29
+			%t32 =l loadl %t3
+			%t33 =w loadw %t32
+			%t34 =l add %t32, 8
+			%t35 =w ceqw %t33, 0
+			jnz %t35, @b2, @b4
+		@b4
+			%t36 =w ceqw %t33, 1
+			jnz %t36, @b1, @b3
+		@b1
+			%t37 =l add %t34, 8
+			stored d_2.0, %t37
+			jmp @b3
+		@b2
+			jmp @b3
+		@b3
+			ret
+		}
+		data $unit = align 8 { w 1, z 4, d d_1, d d_1 }
+		data $none = align 8 { z 24 }
+	`)
+	testCompileFailure(t, "Initializer for Shape must name exactly one member", `
+		type Shape variant { circle F64; square F64 }
+		var s Shape = {1.0}
+	`)
+	testCompileFailure(t, "Initializer for Shape must name exactly one member", `
+		type Shape variant { circle F64; square F64 }
+		fn f() {
+			var s = Shape{circle: 1.0, square: 2.0}
+		}
+	`)
+	testCompileFailure(t, "No such field: rect", `
+		type Shape variant { circle F64; square F64 }
+		fn f() {
+			var s = Shape{rect: 1.0}
+		}
+	`)
+	testCompileFailure(t, "Variant member s.rect can only be read in a match statement", `
+		type Shape variant { circle F64; rect struct { w, h F64 } }
+		fn f(s Shape) {
+			s.rect.w = 1.0
+		}
+	`)
+}
+
+func TestMatch(t *testing.T) {
+	testCompile(t, `
+		type Shape variant {
+			circle F64
+			square F64
+			rect struct { w, h F64 }
+		}
+		fn area(s Shape) F64 {
+			match s {
+			case circle(r):
+				return 3.0 * r * r
+			case rect(d):
+				return d.w * d.h
+			default:
+			}
+			return 0.0
+		}
+	`, `
+		type :d2 = { d 2 }
+		type :UXdYXXd2YY = { { d } { :d2 } }
+		type :wXUXdYXXd2YYY = { w, :UXdYXXd2YY }
+		function d $area(:wXUXdYXXd2YYY %t1) {
+		@start
+			%t2 =w loadw %t1
+			%t3 =l add %t1, 8
+			%t4 =w ceqw %t2, 0
+			jnz %t4, @b1, @b5
+		@b5
+			%t5 =w ceqw %t2, 2
+			jnz %t5, @b2, @b3
+		@b1
+			%t6 =d loadd %t3
+			%t7 =d mul d_3.0, %t6
+			%t8 =d loadd %t3
+			%t9 =d mul %t7, %t8
+			ret %t9
+		@b2
+			%t10 =d loadd %t3
+			%t11 =l add %t3, 8
+			%t12 =d loadd %t11
+			%t13 =d mul %t10, %t12
+			ret %t13
+		@b3
+			jmp @b4
+		@b4
+			ret d_0.0
+		}
+	`)
+	testCompileFailure(t, "Match on Shape is missing cases: square", `
+		type Shape variant { circle F64; square F64 }
+		fn f(s Shape) {
+			match s {
+			case circle:
+			}
+		}
+	`)
+	testCompileFailure(t, "Shape has no variant triangle", `
+		type Shape variant { circle F64; square F64 }
+		fn f(s Shape) {
+			match s {
+			case triangle:
+			default:
+			}
+		}
+	`)
+	testCompileFailure(t, "Duplicate case circle in match", `
+		type Shape variant { circle F64; square F64 }
+		fn f(s Shape) {
+			match s {
+			case circle:
+			case square:
+			case circle:
+			}
+		}
+	`)
+	testCompileFailure(t, "Match value must be a variant, not I32", `
+		fn f(x I32) {
+			match x {
+			}
+		}
+	`)
+}
+
+func TestFor0(t *testing.T) {
+	testMainCompile(t, `
+		var a I32
+		for {a = 0}
+		for ;; {a = 1}
+	`, `
+		%t1 =l alloc4 4
+		storew 0, %t1
+
+	@b1
+	@b2
+		storew 0, %t1
+		jmp @b1
+	@b3
+
+	@b4
+	@b5
+		storew 1, %t1
+		jmp @b4
+	@b6
+	`)
+}
+
+func TestFor1(t *testing.T) {
+	testMainCompile(t, `
+		var a I32
+		for 1 {a = 0}
+		for ; 2; {a = 1}
+	`, `
+		%t1 =l alloc4 4
+		storew 0, %t1
+
+	@b1
+		jnz 1, @b2, @b3
+	@b2
 		storew 0, %t1
 		jmp @b1
 	@b3
@@ -1254,107 +2910,1563 @@ func TestArrayType(t *testing.T) {
 	`)
 }
 
-func TestFunctionCall(t *testing.T) {
+func TestIndex(t *testing.T) {
 	testCompile(t, `
-		fn foo(i I64)
-		fn bar(i I64) {
-			foo(i)
-		}
-		pub fn main() I32 {
-			foo(42)
-			bar(42)
-			return 0
+		type Foo struct { a [U64 4] }
+		fn f(p [I32], i I32) I32 {
+			var foo Foo
+			foo.a[i] = 1
+			var m [[U8 3] 2]
+			m[1][2] = m[0][i]
+			return p[i]
 		}
 	`, `
-		function $bar(l %t1) {
+		function w $f(l %t1, w %t2) {
 		@start
-			%t2 =l alloc8 8
-			storel %t1, %t2
-			%t3 =l loadl %t2
-			call $foo(l %t3)
-			ret
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc4 4
+			storew %t2, %t4
+
+			%t5 =l alloc8 32
+			storel 0, %t5
+			%t6 =l add %t5, 8
+			storel 0, %t6
+			%t7 =l add %t5, 16
+			storel 0, %t7
+			%t8 =l add %t5, 24
+			storel 0, %t8
+
+			%t9 =w loadw %t4
+			%t10 =l extsw %t9
+			%t11 =l mul %t10, 8
+			%t12 =l add %t5, %t11
+			storel 1, %t12
+
+			%t13 =l alloc4 6
+			storeb 0, %t13
+			%t14 =l add %t13, 1
+			storeb 0, %t14
+			%t15 =l add %t13, 2
+			storeb 0, %t15
+			%t16 =l add %t13, 3
+			storeb 0, %t16
+			%t17 =l add %t16, 1
+			storeb 0, %t17
+			%t18 =l add %t16, 2
+			storeb 0, %t18
+
+			%t19 =l add %t13, 3
+			%t20 =l add %t19, 2
+			%t21 =w loadw %t4
+			%t22 =l extsw %t21
+			%t23 =l add %t13, %t22
+			%t24 =w loadub %t23
+			storeb %t24, %t20
+
+			%t25 =l loadl %t3
+			%t26 =w loadw %t4
+			%t27 =l extsw %t26
+			%t28 =l mul %t27, 4
+			%t29 =l add %t25, %t28
+			%t30 =w loadw %t29
+			ret %t30
 		}
-		export function w $main() {
-		@start
-			call $foo(l 42)
-			call $bar(l 42)
-			ret 0
+	`)
+	testCompileFailure(t, "Index must be an integer, not F64", `
+		fn f(p [I32], x F64) I32 {
+			return p[x]
+		}
+	`)
+	testCompileFailure(t, "Index of non-array type I32", `
+		fn f(p, i I32) I32 {
+			return p[i]
+		}
+	`)
+	testCompileFailure(t, "Generic pointer may not be indexed", `
+		fn f(p [], i I32) {
+			_ = p[i]
+		}
+	`)
+	testCompileFailure(t, "Index 4 out of range", `
+		fn f() I32 {
+			var a [I32 4]
+			return a[4]
+		}
+	`)
+	testCompileFailure(t, "Index -1 out of range", `
+		fn f() {
+			var a [I32 4]
+			a[-1] = 1
+		}
+	`)
+	testCompileFailure(t, "Index 2 out of range", `
+		type Foo struct { a [U64 2] }
+		const n = 2
+		fn f(foo [Foo]) U64 {
+			return foo.a[n]
 		}
 	`)
 }
 
-func TestStringLiteral(t *testing.T) {
+func TestLenName(t *testing.T) {
+	// len is only a builtin when no variable of that name is in scope
 	testCompile(t, `
-		fn puts(s [I8]) I32
-		pub fn main() I32 {
-			_ = puts("str0")
-			_ = puts("str0")
-			_ = puts("str1")
-			_ = puts("str1")
-			_ = puts("str2")
-			_ = puts("str2")
-			_ = puts("\e\n\r\t\\\"")
-			_ = puts("\x00\xab\xff")
-			_ = puts("\u0100\U00010000")
-			return 0
+		type Buf struct { data [U8]; len U64 }
+		var table [I32 4]
+		var n = len(table)
+		fn f(b Buf, s [I32 ..]) U64 {
+			var len = b.len
+			return len + n
+		}
+		fn g(len I32) I32 {
+			return len
 		}
 	`, `
-		export function w $main() {
+		type :l2 = { l 2 }
+		function l $f(:l2 %t1, :l2 %t2) {
 		@start
-			%t1 =w call $puts(l $str0)
-			%t2 =w call $puts(l $str0)
-			%t3 =w call $puts(l $str1)
-			%t4 =w call $puts(l $str1)
-			%t5 =w call $puts(l $str2)
-			%t6 =w call $puts(l $str2)
-			%t7 =w call $puts(l $str3)
-			%t8 =w call $puts(l $str4)
-			%t9 =w call $puts(l $str5)
-			ret 0
+			%t3 =l add %t1, 8
+			%t4 =l loadl %t3
+			%t5 =l alloc8 8
+			storel %t4, %t5
+			%t6 =l loadl %t5
+			%t7 =l loadl $n
+			%t8 =l add %t6, %t7
+			ret %t8
+		}
+		function w $g(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =w loadw %t2
+			ret %t3
+		}
+		data $table = align 4 { z 16 }
+		data $n = align 8 { l 4 }
+	`)
+	testCompileFailure(t, "Call of non-function type", `
+		fn f(len U64, s [I32 ..]) U64 {
+			return len(s)
+		}
+	`)
+	testCompileFailure(t, "Incorrect number of arguments in call to len: expected 1, got 2", `
+		fn f(s [I32 ..]) U64 {
+			return len(s, s)
 		}
-		data $str0 = { b "str0", b 0 }
-		data $str1 = { b "str1", b 0 }
-		data $str2 = { b "str2", b 0 }
-		data $str3 = { b 27, b 10, b 13, b 9, b "\"", b 0 }
-		data $str4 = { b 0, b 171, b 255, b 0 }
-		data $str5 = { b 196, b 128, b 240, b 144, b 128, b 128, b 0 }
 	`)
 }
 
-func TestRuneLiteral(t *testing.T) {
-	testMainCompile(t, `
-		var r I32
-		r = 'a'
-		r = '\e'
-		r = '\n'
-		r = '\r'
-		r = '\t'
-		r = '\\'
-		r = '\''
+func TestSlice(t *testing.T) {
+	testCompile(t, `
+		fn f(xs [I32 ..], i U64) I32 {
+			return xs[i]
+		}
+		fn g(p [I16], n I32) U64 {
+			var s = p[1..n]
+			var a [I16 3]
+			s = a[..]
+			return len(s) + len(a)
+		}
 	`, `
-		%t1 =l alloc4 4
-		storew 0, %t1
-		storew 97, %t1
-		storew 27, %t1
-		storew 10, %t1
-		storew 13, %t1
-		storew 9, %t1
-		storew 92, %t1
-		storew 39, %t1
-	`)
-}
+		type :l2 = { l 2 }
+		function w $f(:l2 %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t2, %t3
 
-func TestTypeCheck(t *testing.T) {
-	testCompileFailure(t, "Expression returning non-void cannot be used as statement", `
-		fn f() {
-			4 + 2
+			%t4 =l loadl %t1
+			%t5 =l add %t1, 8
+			%t6 =l loadl %t5
+			%t7 =l loadl %t3
+			%t8 =w cultl %t7, %t6
+			jnz %t8, @b1, @b2
+		@b2
+			call $abort()
+		@b1
+			%t9 =l mul %t7, 4
+			%t10 =l add %t4, %t9
+			%t11 =w loadw %t10
+			ret %t11
+		}
+		function l $g(l %t1, w %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc4 4
+			storew %t2, %t4
+
+			%t5 =l loadl %t3
+			%t6 =w loadw %t4
+			%t7 =l extsw %t6
+			%t8 =w culel 1, %t7
+			jnz %t8, @b1, @b2
+		@b2
+			call $abort()
+		@b1
+			%t9 =l alloc8 16
+			%t10 =l add %t5, 2
+			storel %t10, %t9
+			%t11 =l sub %t7, 1
+			%t12 =l add %t9, 8
+			storel %t11, %t12
+			%t13 =l alloc8 16
+			%t14 =l loadl %t9
+			storel %t14, %t13
+			%t15 =l add %t13, 8
+			%t16 =l add %t9, 8
+			%t17 =l loadl %t16
+			storel %t17, %t15
+
+			%t18 =l alloc4 6
+			storeh 0, %t18
+			%t19 =l add %t18, 2
+			storeh 0, %t19
+			%t20 =l add %t18, 4
+			storeh 0, %t20
+
+			%t21 =l alloc8 16
+			storel %t18, %t21
+			%t22 =l sub 3, 0
+			%t23 =l add %t21, 8
+			storel %t22, %t23
+			%t24 =l loadl %t21
+			storel %t24, %t13
+			%t25 =l add %t13, 8
+			%t26 =l add %t21, 8
+			%t27 =l loadl %t26
+			storel %t27, %t25
+
+			%t28 =l add %t13, 8
+			%t29 =l loadl %t28
+			%t30 =l add %t29, 3
+			ret %t30
 		}
 	`)
 
-	testCompileFailure(t, "Type error in call to f: [I8] is not I32", `
-		fn f(x I32)
-		fn g() {
-			f("")
+	c := NewCompiler()
+	c.NoBoundsCheck = true
+	testCompileWith(t, c, `
+		fn f(xs [I32 ..], i U64) I32 {
+			return xs[i]
+		}
+	`, `
+		type :l2 = { l 2 }
+		function w $f(:l2 %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t2, %t3
+			%t4 =l loadl %t1
+			%t5 =l add %t1, 8
+			%t6 =l loadl %t5
+			%t7 =l loadl %t3
+			%t8 =l mul %t7, 4
+			%t9 =l add %t4, %t8
+			%t10 =w loadw %t9
+			ret %t10
 		}
 	`)
+
+	testCompileFailure(t, "Index 5 out of range", `
+		fn f() {
+			var a [I32 4]
+			_ = a[..5]
+		}
+	`)
+	testCompileFailure(t, "Slice of pointer must have an upper bound", `
+		fn f(p [I32]) {
+			_ = p[1..]
+		}
+	`)
+	testCompileFailure(t, "Length of non-array type [I32]", `
+		fn f(p [I32]) U64 {
+			return len(p)
+		}
+	`)
+	testCompileFailure(t, "Type error in assignment: [I8 ..] is not [I16 ..]", `
+		fn f(s [I16 ..]) {
+			var a [I8 3]
+			s = a[..]
+		}
+	`)
+}
+
+func TestMultipleReturn(t *testing.T) {
+	testCompile(t, `
+		fn divmod(a, b U64) (U64, U64) {
+			return a / b, a % b
+		}
+		fn f(x, y U64) U64 {
+			var q, r U64
+			q, r = divmod(x, y)
+			_, r = divmod(r, 2)
+			q, r = r, q
+			return q
+		}
+	`, `
+		type :l2 = { l 2 }
+		function :l2 $divmod(l %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			storel %t2, %t4
+
+			%t5 =l alloc8 16
+			%t6 =l loadl %t3
+			%t7 =l loadl %t4
+			%t8 =l udiv %t6, %t7
+			storel %t8, %t5
+			%t9 =l add %t5, 8
+			%t10 =l loadl %t3
+			%t11 =l loadl %t4
+			%t12 =l urem %t10, %t11
+			storel %t12, %t9
+			ret %t5
+		}
+		function l $f(l %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			storel %t2, %t4
+
+			%t5 =l alloc8 8
+			storel 0, %t5
+			%t6 =l alloc8 8
+			storel 0, %t6
+
+			%t7 =l loadl %t3
+			%t8 =l loadl %t4
+			%t9 =:l2 call $divmod(l %t7, l %t8)
+			%t10 =l loadl %t9
+			%t11 =l add %t9, 8
+			%t12 =l loadl %t11
+			storel %t10, %t5
+			storel %t12, %t6
+
+			%t13 =l loadl %t6
+			%t14 =:l2 call $divmod(l %t13, l 2)
+			%t15 =l loadl %t14
+			%t16 =l add %t14, 8
+			%t17 =l loadl %t16
+			storel %t17, %t6
+
+			%t18 =l loadl %t6
+			%t19 =l loadl %t5
+			storel %t18, %t5
+			storel %t19, %t6
+
+			%t20 =l loadl %t5
+			ret %t20
+		}
+	`)
+	testCompileFailure(t, "Wrong number of return values: expected 2, got 3", `
+		fn f() (I32, I32) {
+			return 1, 2, 3
+		}
+	`)
+	testCompileFailure(t, "Assignment mismatch: 3 variables but 2 values", `
+		fn f() (I32, I32)
+		fn g() {
+			var a, b, c I32
+			a, b, c = f()
+		}
+	`)
+	testCompileFailure(t, "Type error in assignment: I32 is not [I8]", `
+		fn f() (I32, I32)
+		fn g() {
+			var a I32
+			var p [I8]
+			a, p = f()
+		}
+	`)
+	testCompileFailure(t, "Type error in assignment: (I32, I32) is not I32", `
+		fn f() (I32, I32)
+		fn g() {
+			var a I32
+			a = f()
+		}
+	`)
+
+	testCompile(t, `
+		fn divmod(a, b U64) (U64, U64) {
+			return a / b, a % b
+		}
+		fn f(a U64) U64 {
+			var q, r = divmod(a, 10)
+			var x, y U64 = divmod(q, r)
+			return x + y
+		}
+	`, `
+		type :l2 = { l 2 }
+		function :l2 $divmod(l %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			storel %t2, %t4
+			%t5 =l alloc8 16
+			%t6 =l loadl %t3
+			%t7 =l loadl %t4
+			%t8 =l udiv %t6, %t7
+			storel %t8, %t5
+			%t9 =l add %t5, 8
+			%t10 =l loadl %t3
+			%t11 =l loadl %t4
+			%t12 =l urem %t10, %t11
+			storel %t12, %t9
+			ret %t5
+		}
+		function l $f(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			%t4 =:l2 call $divmod(l %t3, l 10)
+			%t5 =l loadl %t4
+			%t6 =l add %t4, 8
+			%t7 =l loadl %t6
+			%t8 =l alloc8 8
+			storel %t5, %t8
+			%t9 =l alloc8 8
+			storel %t7, %t9
+			%t10 =l loadl %t8
+			%t11 =l loadl %t9
+			%t12 =:l2 call $divmod(l %t10, l %t11)
+			%t13 =l loadl %t12
+			%t14 =l add %t12, 8
+			%t15 =l loadl %t14
+			%t16 =l alloc8 8
+			storel %t13, %t16
+			%t17 =l alloc8 8
+			storel %t15, %t17
+			%t18 =l loadl %t16
+			%t19 =l loadl %t17
+			%t20 =l add %t18, %t19
+			ret %t20
+		}
+	`)
+	testCompileFailure(t, "Assignment mismatch: 3 variables but 2 values", `
+		fn f() (I32, I32)
+		fn g() {
+			var a, b, c = f()
+		}
+	`)
+	testCompileFailure(t, "Type error in initializer: I32 is not I64", `
+		fn f() (I32, I32)
+		fn g() {
+			var a, b I64 = f()
+		}
+	`)
+	testCompileFailure(t, "Multiple values used in single-value context: f()", `
+		fn f() (I32, I32)
+		fn g() {
+			var t = f()
+		}
+	`)
+	testCompileFailure(t, "Assignment mismatch: 1 variables but 2 values", `
+		fn f() (I32, I32)
+		fn g() {
+			_ = f()
+		}
+	`)
+	testCompileFailure(t, "Tuple type (I32, I32) can only be used as a function result", `
+		fn f() {
+			var t (I32, I32)
+		}
+	`)
+	testCompileFailure(t, "Type error in return: I32 is not (I32, I32)", `
+		fn f(a I32) (I32, I32) {
+			return a / 2
+		}
+	`)
+	testCompileFailure(t, "Type error in return: (I32, I32) is not I32", `
+		fn f() (I32, I32)
+		fn g() I32 {
+			return f()
+		}
+	`)
+}
+
+func TestFunctionCall(t *testing.T) {
+	testCompile(t, `
+		fn foo(i I64)
+		fn bar(i I64) {
+			foo(i)
+		}
+		pub fn main() I32 {
+			foo(42)
+			bar(42)
+			return 0
+		}
+	`, `
+		function $bar(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			call $foo(l %t3)
+			ret
+		}
+		export function w $main() {
+		@start
+			call $foo(l 42)
+			call $bar(l 42)
+			ret 0
+		}
+	`)
+}
+
+func TestStringLiteral(t *testing.T) {
+	testCompile(t, `
+		fn puts(s [const I8]) I32
+		pub fn main() I32 {
+			_ = puts("str0")
+			_ = puts("str0")
+			_ = puts("str1")
+			_ = puts("str1")
+			_ = puts("str2")
+			_ = puts("str2")
+			_ = puts("\e\n\r\t\\\"")
+			_ = puts("\x00\xab\xff")
+			_ = puts("\u0100\U00010000")
+			return 0
+		}
+	`, `
+		export function w $main() {
+		@start
+			%t1 =w call $puts(l $str0)
+			%t2 =w call $puts(l $str0)
+			%t3 =w call $puts(l $str1)
+			%t4 =w call $puts(l $str1)
+			%t5 =w call $puts(l $str2)
+			%t6 =w call $puts(l $str2)
+			%t7 =w call $puts(l $str3)
+			%t8 =w call $puts(l $str4)
+			%t9 =w call $puts(l $str5)
+			ret 0
+		}
+		data $str0 = { b "str0", b 0 }
+		data $str1 = { b "str1", b 0 }
+		data $str2 = { b "str2", b 0 }
+		data $str3 = { b 27, b 10, b 13, b 9, b "\"", b 0 }
+		data $str4 = { b 0, b 171, b 255, b 0 }
+		data $str5 = { b 196, b 128, b 240, b 144, b 128, b 128, b 0 }
+	`)
+}
+
+func TestRuneLiteral(t *testing.T) {
+	testMainCompile(t, `
+		var r I32
+		r = 'a'
+		r = '\e'
+		r = '\n'
+		r = '\r'
+		r = '\t'
+		r = '\\'
+		r = '\''
+	`, `
+		%t1 =l alloc4 4
+		storew 0, %t1
+		storew 97, %t1
+		storew 27, %t1
+		storew 10, %t1
+		storew 13, %t1
+		storew 9, %t1
+		storew 92, %t1
+		storew 39, %t1
+	`)
+}
+
+func TestTypeCheck(t *testing.T) {
+	testCompileFailure(t, "Expression returning non-void cannot be used as statement", `
+		fn f() {
+			4 + 2
+		}
+	`)
+
+	testCompileFailure(t, "Type error in call to f: [const I8] is not I32", `
+		fn f(x I32)
+		fn g() {
+			f("")
+		}
+	`)
+}
+
+func TestMethod(t *testing.T) {
+	testCompile(t, `
+		type Vec struct {
+			n I32
+		}
+		fn (v [Vec]) push(x I32) {
+			v.n = v.n + x
+		}
+		fn (v Vec) size() I32 {
+			return v.n
+		}
+		fn f(p [Vec]) I32 {
+			var v Vec
+			v.push(1)
+			p.push(2)
+			Vec.push(&v, 3)
+			return v.size() + p.size()
+		}
+	`, `
+		type :w = { w }
+		function $Vec.push(l %t1, w %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc4 4
+			storew %t2, %t4
+			%t5 =l loadl %t3
+			%t6 =l loadl %t3
+			%t7 =w loadw %t6
+			%t8 =w loadw %t4
+			%t9 =w add %t7, %t8
+			storew %t9, %t5
+			ret
+		}
+		function w $Vec.size(:w %t1) {
+		@start
+			%t2 =w loadw %t1
+			ret %t2
+		}
+		function w $f(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l alloc4 4
+			storew 0, %t3
+		
+			call $Vec.push(l %t3, w 1)
+			%t4 =l loadl %t2
+			call $Vec.push(l %t4, w 2)
+			call $Vec.push(l %t3, w 3)
+		
+			%t5 =w call $Vec.size(:w %t3)
+			%t6 =l loadl %t2
+			%t7 =w call $Vec.size(:w %t6)
+			%t8 =w add %t5, %t7
+			ret %t8
+		}
+	`)
+	testCompileFailure(t, "Receiver of method f must be a type defined in the same namespace, or a pointer to one", `
+		fn (x I32) f() {}
+	`)
+	testCompileFailure(t, "No such field: pop", `
+		type Vec struct { n I32 }
+		fn (v [Vec]) push(x I32) {}
+		fn f(v Vec) {
+			v.pop()
+		}
+	`)
+}
+
+func TestInterface(t *testing.T) {
+	testCompile(t, `
+		type Writer interface {
+			write(buf [const I8], n U64) I64
+			size() U64
+		}
+		type File struct {
+			fd I32
+			written U64
+		}
+		fn (f [File]) write(buf [const I8], n U64) I64 {
+			f.written = f.written + n
+			return write(f.fd, buf, n)
+		}
+		fn (f File) size() U64 {
+			return f.written
+		}
+		fn write(fd I32, buf [const I8], n U64) I64
+		var stdout File = {1, 0}
+		var out Writer = &stdout
+		fn greet(w Writer) U64 {
+			var n = w.write("hi", 2)
+			return w.size()
+		}
+		pub fn main() I32 {
+			var f File
+			var s = greet(&f)
+			return 0
+		}
+	`, `
+		type :l2 = { l 2 }
+		type :wl = { w, l }
+		function l $File.write(l %t1, l %t2, l %t3) {
+		@start
+			%t4 =l alloc8 8
+			storel %t1, %t4
+			%t5 =l alloc8 8
+			storel %t2, %t5
+			%t6 =l alloc8 8
+			storel %t3, %t6
+			%t7 =l loadl %t4
+			%t8 =l add %t7, 8
+			%t9 =l loadl %t4
+			%t10 =l add %t9, 8
+			%t11 =l loadl %t10
+			%t12 =l loadl %t6
+			%t13 =l add %t11, %t12
+			storel %t13, %t8
+			%t14 =l loadl %t4
+			%t15 =w loadw %t14
+			%t16 =l loadl %t5
+			%t17 =l loadl %t6
+			%t18 =l call $write(w %t15, l %t16, l %t17)
+			ret %t18
+		}
+		function l $File.size(:wl %t1) {
+		@start
+			%t2 =l add %t1, 8
+			%t3 =l loadl %t2
+			ret %t3
+		}
+		function l $greet(:l2 %t1) {
+		@start
+			%t2 =l loadl %t1
+			%t3 =l add %t1, 8
+			%t4 =l loadl %t3
+			%t5 =l loadl %t4
+			%t6 =l call %t5(l %t2, l $str0, l 2)
+			%t7 =l alloc8 8
+			storel %t6, %t7
+			%t8 =l loadl %t1
+			%t9 =l add %t1, 8
+			%t10 =l loadl %t9
+			%t11 =l add %t10, 8
+			%t12 =l loadl %t11
+			%t13 =l call %t12(l %t8)
+			ret %t13
+		}
+		export function w $main() {
+		@start
+			%t1 =l alloc8 16
+			storew 0, %t1
+			%t2 =l add %t1, 8
+			storel 0, %t2
+			%t3 =l alloc8 16
+			storel %t1, %t3
+			%t4 =l add %t3, 8
+			storel $vtable.0, %t4
+			%t5 =l call $greet(:l2 %t3)
+			%t6 =l alloc8 8
+			storel %t5, %t6
+			ret 0
+		}
+		function l $File.size.thunk(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			%t4 =l call $File.size(:wl %t3)
+			ret %t4
+		}
+		data $str0 = { b "hi", b 0 }
+		data $stdout = align 8 { w 1, z 4, l 0 }
+		data $out = align 8 { l $stdout, l $vtable.0 }
+		data $vtable.0 = align 8 { l $File.write, l $File.size.thunk }
+	`)
+	// Vtables do not collide with user globals
+	testCompile(t, `
+		type Closer interface { close() }
+		type File struct { fd I32 }
+		fn (f [File]) close() {}
+		var file File
+		var vtable0 I64 = 1
+		var c Closer = &file
+	`, `
+		function $File.close(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			ret
+		}
+		data $file = align 4 { z 4 }
+		data $vtable0 = align 8 { l 1 }
+		data $c = align 8 { l $file, l $vtable.0 }
+		data $vtable.0 = align 8 { l $File.close }
+	`)
+	testCompileFailure(t, "File does not implement Writer: missing method close", `
+		type Writer interface { close() }
+		type File struct { fd I32 }
+		fn f(file [File]) {
+			var w Writer = file
+		}
+	`)
+	testCompileFailure(t, "File does not implement Writer: method close has type fn() I32, not fn()", `
+		type Writer interface { close() }
+		type File struct { fd I32 }
+		fn (f [File]) close() I32 { return 0 }
+		fn f(file [File]) {
+			var w Writer = file
+		}
+	`)
+	testCompileFailure(t, "Type error in initializer: File is not Writer", `
+		type Writer interface { close() }
+		type File struct { fd I32 }
+		fn (f [File]) close() {}
+		fn f(file File) {
+			var w Writer = file
+		}
+	`)
+	testCompileFailure(t, "Writer has no method open", `
+		type Writer interface { close() }
+		fn f(w Writer) {
+			w.open()
+		}
+	`)
+}
+
+func TestGeneric(t *testing.T) {
+	testCompile(t, `
+		type Pair[A, B] struct {
+			a A
+			b B
+		}
+		type Node[T] struct {
+			v T
+			next [Node[T]]
+		}
+		fn max[T](a, b T) T {
+			if a > b {
+				return a
+			}
+			return b
+		}
+		fn first[A, B](p [Pair[A, B]]) A {
+			return p.a
+		}
+		fn count[T](n [Node[T]]) I32 {
+			return cast(n.next.v, I32)
+		}
+		fn f(x I32, y U8) I64 {
+			var p Pair[I32, U8] = {x, y}
+			var n Node[F64]
+			var m = max(x, 2)
+			var q = max(1, 2)
+			return cast(first(&p) + cast(max(y, y), I32) + count(&n) + m, I64) + q
+		}
+	`, `
+		function l $f(w %t1, w %t2) {
+		@start
+			%t3 =l alloc4 4
+			storew %t1, %t3
+			%t4 =l alloc4 1
+			storeb %t2, %t4
+			%t5 =l alloc4 8
+			%t6 =w loadw %t3
+			storew %t6, %t5
+			%t7 =l add %t5, 4
+			%t8 =w loadub %t4
+			storeb %t8, %t7
+			%t9 =l alloc8 16
+			stored 0, %t9
+			%t10 =l add %t9, 8
+			storel 0, %t10
+			%t11 =w loadw %t3
+			%t12 =w call $max.I32(w %t11, w 2)
+			%t13 =l alloc4 4
+			storew %t12, %t13
+			%t14 =l call $max.I64(l 1, l 2)
+			%t15 =l alloc8 8
+			storel %t14, %t15
+			%t16 =w call $first.I32.U8(l %t5)
+			%t17 =w loadub %t4
+			%t18 =w loadub %t4
+			%t19 =w call $max.U8(w %t17, w %t18)
+			%t20 =w extub %t19
+			%t21 =w add %t16, %t20
+			%t22 =w call $count.F64(l %t9)
+			%t23 =w add %t21, %t22
+			%t24 =w loadw %t13
+			%t25 =w add %t23, %t24
+			%t26 =l extsw %t25
+			%t27 =l loadl %t15
+			%t28 =l add %t26, %t27
+			ret %t28
+		}
+		function w $max.I32(w %t1, w %t2) {
+		@start
+			%t3 =l alloc4 4
+			storew %t1, %t3
+			%t4 =l alloc4 4
+			storew %t2, %t4
+			%t5 =w loadw %t3
+			%t6 =w loadw %t4
+			%t7 =w csgtw %t5, %t6
+			jnz %t7, @b1, @b2
+		@b1
+			%t8 =w loadw %t3
+			ret %t8
+		@b2
+		@b3
+			%t9 =w loadw %t4
+			ret %t9
+		}
+		function l $max.I64(l %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			storel %t2, %t4
+			%t5 =l loadl %t3
+			%t6 =l loadl %t4
+			%t7 =w csgtl %t5, %t6
+			jnz %t7, @b1, @b2
+		@b1
+			%t8 =l loadl %t3
+			ret %t8
+		@b2
+		@b3
+			%t9 =l loadl %t4
+			ret %t9
+		}
+		function w $first.I32.U8(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			%t4 =w loadw %t3
+			ret %t4
+		}
+		function w $max.U8(w %t1, w %t2) {
+		@start
+			%t3 =l alloc4 1
+			storeb %t1, %t3
+			%t4 =l alloc4 1
+			storeb %t2, %t4
+			%t5 =w loadub %t3
+			%t6 =w loadub %t4
+			%t7 =w cugtw %t5, %t6
+			jnz %t7, @b1, @b2
+		@b1
+			%t8 =w loadub %t3
+			ret %t8
+		@b2
+		@b3
+			%t9 =w loadub %t4
+			ret %t9
+		}
+		function w $count.F64(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			%t4 =l add %t3, 8
+			%t5 =l loadl %t4
+			%t6 =d loadd %t5
+			%t7 =w dtosi %t6
+			ret %t7
+		}
+	`)
+	testCompileFailure(t, "Cannot infer type parameter T in call to zero; give the type arguments explicitly", `
+		fn zero[T]() T { return 0 }
+		fn f() { var x I32 = zero() }
+	`)
+	testCompileFailure(t, "Generic type Box used without type arguments", `
+		type Box[T] struct { v T }
+		var b Box
+	`)
+	testCompileFailure(t, "Wrong number of type arguments for Box: expected 1, got 2", `
+		type Box[T] struct { v T }
+		var b Box[I32, I64]
+	`)
+	testCompileFailure(t, "Generic function id used as value", `
+		fn id[T](x T) T { return x }
+		fn f() { var p = id }
+	`)
+
+	// Type arguments may be given explicitly
+	testCompile(t, `
+		type Pair[A, B] struct {
+			a A
+			b B
+		}
+		type Color enum I32 { red, green }
+		fn sz[T]() U64 {
+			return sizeof(T)
+		}
+		fn id[T](x T) T {
+			return x
+		}
+		fn f(q [I32]) U64 {
+			var a [I32 2]
+			var p = &id[[I8]]
+			var x = id[I32](1)
+			x += a[Color.green] + a[[q]]
+			return sz[Pair[I8, I64]]() + sz[[I8]]() + cast(x, U64)
+		}
+	`, `
+		function l $f(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l alloc4 8
+			storew 0, %t3
+			%t4 =l add %t3, 4
+			storew 0, %t4
+			%t5 =l alloc8 8
+			storel $id._5bI8_5d, %t5
+			%t6 =w call $id.I32(w 1)
+			%t7 =l alloc4 4
+			storew %t6, %t7
+			%t8 =w loadw %t7
+			%t9 =l add %t3, 4
+			%t10 =w loadw %t9
+			%t11 =l loadl %t2
+			%t12 =w loadw %t11
+			%t13 =l extsw %t12
+			%t14 =l mul %t13, 4
+			%t15 =l add %t3, %t14
+			%t16 =w loadw %t15
+			%t17 =w add %t10, %t16
+			%t18 =w add %t8, %t17
+			storew %t18, %t7
+			%t19 =l call $sz.Pair_5bI8_2c_20I64_5d()
+			%t20 =l call $sz._5bI8_5d()
+			%t21 =l add %t19, %t20
+			%t22 =w loadw %t7
+			%t23 =l extsw %t22
+			%t24 =l add %t21, %t23
+			ret %t24
+		}
+		function l $id._5bI8_5d(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			ret %t3
+		}
+		function w $id.I32(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =w loadw %t2
+			ret %t3
+		}
+		function l $sz.Pair_5bI8_2c_20I64_5d() {
+		@start
+			ret 16
+		}
+		function l $sz._5bI8_5d() {
+		@start
+			ret 8
+		}
+	`)
+	testCompileFailure(t, "Wrong number of type arguments for id: expected 1, got 2", `
+		fn id[T](x T) T { return x }
+		fn f() { var x = id[I32, I64](1) }
+	`)
+	testCompileFailure(t, "Type error in call to id[I64]: I32 is not I64", `
+		fn id[T](x T) T { return x }
+		fn f(y I32) { var x = id[I64](y) }
+	`)
+	testCompileFailure(t, "Type arguments given to non-generic f", `
+		fn f(x I32) { f[I32](x) }
+	`)
+}
+
+func TestFuncLiteral(t *testing.T) {
+	testCompile(t, `
+		fn qsort(base [], n, size U64, cmp fn([], []) I32)
+		var dflt fn(I32) I32 = fn(x I32) I32 {
+			return x
+		}
+		ns util {
+			fn sort(a [I32], n U64) {
+				qsort(a, n, 4, fn(a, b []) I32 {
+					var x [I32] = a
+					var y [I32] = b
+					return [x] - [y]
+				})
+			}
+		}
+		fn apply(f fn(I32) I32, x I32) I32 {
+			return f(x)
+		}
+		fn g() I32 {
+			return apply(fn(x I32) I32 { return x * 2 }, 3) + dflt(1)
+		}
+	`, `
+		function $util.sort(l %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			storel %t2, %t4
+			%t5 =l loadl %t3
+			%t6 =l loadl %t4
+			call $qsort(l %t5, l %t6, l 4, l $util.fn.1)
+			ret
+		}
+		function w $apply(l %t1, w %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc4 4
+			storew %t2, %t4
+			%t5 =l loadl %t3
+			%t6 =w loadw %t4
+			%t7 =w call %t5(w %t6)
+			ret %t7
+		}
+		function w $g() {
+		@start
+			%t1 =w call $apply(l $fn.2, w 3)
+			%t2 =l loadl $dflt
+			%t3 =w call %t2(w 1)
+			%t4 =w add %t1, %t3
+			ret %t4
+		}
+		function w $fn.0(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =w loadw %t2
+			ret %t3
+		}
+		function w $util.fn.1(l %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			storel %t2, %t4
+			%t5 =l loadl %t3
+			%t6 =l alloc8 8
+			storel %t5, %t6
+			%t7 =l loadl %t4
+			%t8 =l alloc8 8
+			storel %t7, %t8
+			%t9 =l loadl %t6
+			%t10 =w loadw %t9
+			%t11 =l loadl %t8
+			%t12 =w loadw %t11
+			%t13 =w sub %t10, %t12
+			ret %t13
+		}
+		function w $fn.2(w %t1) {
+		@start
+			%t2 =l alloc4 4
+			storew %t1, %t2
+			%t3 =w loadw %t2
+			%t4 =w mul %t3, 2
+			ret %t4
+		}
+		data $dflt = align 8 { l $fn.0 }
+	`)
+	testCompileFailure(t, "Undefined variable: y", `
+		fn f(y I32) fn(I32) I32 {
+			return fn(x I32) I32 { return x + y }
+		}
+	`)
+}
+
+func TestConstPointer(t *testing.T) {
+	testCompile(t, `
+		type Point struct { x, y I32 }
+		fn strlen(s [const I8]) U64
+		fn get(p [const Point]) I32 {
+			var q [const I32] = &p.y
+			return p.x + [q]
+		}
+		fn f(p [Point]) U64 {
+			p.x = get(p)
+			return strlen("hi")
+		}
+	`, `
+		function w $get(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			%t4 =l add %t3, 4
+			%t5 =l alloc8 8
+			storel %t4, %t5
+			%t6 =l loadl %t2
+			%t7 =w loadw %t6
+			%t8 =l loadl %t5
+			%t9 =w loadw %t8
+			%t10 =w add %t7, %t9
+			ret %t10
+		}
+		function l $f(l %t1) {
+		@start
+			%t2 =l alloc8 8
+			storel %t1, %t2
+			%t3 =l loadl %t2
+			%t4 =l loadl %t2
+			%t5 =w call $get(l %t4)
+			storew %t5, %t3
+			%t6 =l call $strlen(l $str0)
+			ret %t6
+		}
+		data $str0 = { b "hi", b 0 }
+	`)
+	testCompileFailure(t, "Cannot assign through const pointer: p.x", `
+		type Point struct { x, y I32 }
+		fn f(p [const Point]) {
+			p.x = 1
+		}
+	`)
+	testCompileFailure(t, "Cannot assign through const pointer: [p]", `
+		fn f(p [const I32]) {
+			[p] = 1
+		}
+	`)
+	testCompileFailure(t, "Cannot assign through const pointer: p[1]", `
+		fn f(p [const I32]) {
+			p[1] = 1
+		}
+	`)
+	testCompileFailure(t, "Type error in initializer: [const I8] is not [I8]", `
+		fn f() {
+			var s [I8] = "hi"
+		}
+	`)
+	testCompileFailure(t, "Type error in call to f: [const I32] is not [I32]", `
+		fn f(p [I32])
+		fn g(p [const I32]) {
+			f(p)
+		}
+	`)
+}
+
+func TestConstSlice(t *testing.T) {
+	testCompile(t, `
+		fn f(s [const I8], n U64) I8 {
+			var t = s[0..n]
+			var u [const I8 ..] = t[1..]
+			return u[0]
+		}
+	`, `
+		function w $f(l %t1, l %t2) {
+		@start
+			%t3 =l alloc8 8
+			storel %t1, %t3
+			%t4 =l alloc8 8
+			storel %t2, %t4
+			%t5 =l loadl %t3
+			%t6 =l loadl %t4
+			%t7 =w culel 0, %t6
+			jnz %t7, @b1, @b2
+		@b2
+			call $abort()
+		@b1
+			%t8 =l alloc8 16
+			storel %t5, %t8
+			%t9 =l sub %t6, 0
+			%t10 =l add %t8, 8
+			storel %t9, %t10
+			%t11 =l alloc8 16
+			%t12 =l loadl %t8
+			storel %t12, %t11
+			%t13 =l add %t11, 8
+			%t14 =l add %t8, 8
+			%t15 =l loadl %t14
+			storel %t15, %t13
+			%t16 =l loadl %t11
+			%t17 =l add %t11, 8
+			%t18 =l loadl %t17
+			%t19 =w culel 1, %t18
+			jnz %t19, @b3, @b4
+		@b4
+			call $abort()
+		@b3
+			%t20 =l alloc8 16
+			%t21 =l add %t16, 1
+			storel %t21, %t20
+			%t22 =l sub %t18, 1
+			%t23 =l add %t20, 8
+			storel %t22, %t23
+			%t24 =l alloc8 16
+			%t25 =l loadl %t20
+			storel %t25, %t24
+			%t26 =l add %t24, 8
+			%t27 =l add %t20, 8
+			%t28 =l loadl %t27
+			storel %t28, %t26
+			%t29 =l loadl %t24
+			%t30 =l add %t24, 8
+			%t31 =l loadl %t30
+			%t32 =w cultl 0, %t31
+			jnz %t32, @b5, @b6
+		@b6
+			call $abort()
+		@b5
+			%t33 =w loadsb %t29
+			ret %t33
+		}
+	`)
+	testCompileFailure(t, "Cannot assign through const pointer: t[0]", `
+		fn f() {
+			var s [const I8] = "abc"
+			var t = s[0..2]
+			t[0] = 1
+		}
+	`)
+	testCompileFailure(t, "Cannot assign through const pointer: t[0]", `
+		fn f(p [const [I32 4]]) {
+			var t = [p][..]
+			t[0] = 1
+		}
+	`)
+	testCompileFailure(t, "Type error in initializer: [const I8 ..] is not [I8 ..]", `
+		fn f(s [const I8 ..]) {
+			var t [I8 ..] = s
+		}
+	`)
+	testCompileFailure(t, "Type error in call to f: [const I8 ..] is not [I8 ..]", `
+		fn f(s [I8 ..])
+		fn g(s [const I8]) {
+			f(s[0..1])
+		}
+	`)
+}
+
+func TestBool(t *testing.T) {
+	testCompile(t, `
+		fn f(a, b I64, p [I32]) Bool {
+			var ok = a < b && p
+			if !ok || a == 0 {
+				return false
+			}
+			return ok != true
+		}
+	`, `
+		function w $f(l %t1, l %t2, l %t3) {
+		@start
+			%t4 =l alloc8 8
+			storel %t1, %t4
+			%t5 =l alloc8 8
+			storel %t2, %t5
+			%t6 =l alloc8 8
+			storel %t3, %t6
+			%t7 =l loadl %t4
+			%t8 =l loadl %t5
+			%t9 =w csltl %t7, %t8
+			%t10 =w copy %t9
+			jnz %t10, @b1, @b2
+		@b1
+			%t11 =l loadl %t6
+			%t12 =w cnel %t11, 0
+			%t10 =w copy %t12
+		@b2
+			%t13 =l alloc4 1
+			storeb %t10, %t13
+			%t14 =w loadub %t13
+			%t15 =w ceqw 0, %t14
+			%t16 =w copy %t15
+			jnz %t16, @b7, @b6
+		@b6
+			%t17 =l loadl %t4
+			%t18 =w ceql %t17, 0
+			%t16 =w copy %t18
+		@b7
+			jnz %t16, @b3, @b4
+		@b3
+			ret 0
+		@b4
+		@b5
+			%t19 =w loadub %t13
+			%t20 =w cnew %t19, 1
+			ret %t20
+		}
+	`)
+
+	strict := func() *Compiler {
+		c := NewCompiler()
+		c.StrictBool = true
+		return c
+	}
+	testCompileWith(t, strict(), `
+		fn f(a I32, b Bool) Bool {
+			for b && a > 0 {
+				b = !b ^ true
+			}
+			return b == false
+		}
+	`, `
+		function w $f(w %t1, w %t2) {
+		@start
+			%t3 =l alloc4 4
+			storew %t1, %t3
+			%t4 =l alloc4 1
+			storeb %t2, %t4
+		@b1
+			%t5 =w loadub %t4
+			%t6 =w copy %t5
+			jnz %t6, @b4, @b5
+		@b4
+			%t7 =w loadw %t3
+			%t8 =w csgtw %t7, 0
+			%t6 =w copy %t8
+		@b5
+			jnz %t6, @b2, @b3
+		@b2
+			%t9 =w loadub %t4
+			%t10 =w ceqw 0, %t9
+			%t11 =w xor %t10, 1
+			storeb %t11, %t4
+			jmp @b1
+		@b3
+			%t12 =w loadub %t4
+			%t13 =w ceqw %t12, 0
+			ret %t13
+		}
+	`)
+	testCompileFailureWith(t, strict(), "Condition must be Bool, not I32", `
+		fn f(a I32) {
+			if a {}
+		}
+	`)
+	testCompileFailureWith(t, strict(), "Operand of && must be Bool, not [I32]", `
+		fn f(a Bool, p [I32]) Bool {
+			return a && p
+		}
+	`)
+	testCompileFailure(t, "Operator + is not defined for Bool", `
+		fn f(a, b Bool) Bool {
+			return a + b
+		}
+	`)
+	testCompileFailure(t, "Operator < is not defined for Bool", `
+		fn f(a Bool) Bool {
+			return a < true
+		}
+	`)
+	testCompileFailure(t, "Operator - is not defined for Bool", `
+		fn f(a Bool) Bool {
+			return -a
+		}
+	`)
+	testCompileFailure(t, "Condition must be Bool, an integer or a pointer, not F64", `
+		fn f(x F64) {
+			for x {}
+		}
+	`)
+	testCompileFailure(t, "Type error in assignment: Bool is not I32", `
+		fn f(a, b I32) {
+			a = a < b
+		}
+	`)
+}
+
+func TestLiteralSuffix(t *testing.T) {
+	testCompile(t, `
+		var a = 10u8
+		var b = 1.5f32
+		var c F64 = 1e3 + 0x1p-2
+		fn f(x U8) U64 {
+			return cast(x + 255, U64) + 18446744073709551615u64
+		}
+	`, `		function l $f(w %t1) {
+		@start
+			%t2 =l alloc4 1
+			storeb %t1, %t2
+			%t3 =w loadub %t2
+			%t4 =w add %t3, 255
+			%t5 =w extub %t4
+			%t6 =l extub %t5
+			%t7 =l add %t6, -1
+			ret %t7
+		}
+		data $a = align 1 { b 10 }
+		data $b = align 4 { s s_1.5 }
+		data $c = align 8 { d d_1000.25 }
+	`)
+	testCompileFailure(t, "Type error in binary expression: U8 is not I32", `
+		fn f(x I32) I32 {
+			return x + 1u8
+		}
+	`)
+	testCompileFailure(t, "Constant 300 does not fit in U8", `
+		fn f(x U8) U8 {
+			return x + 300
+		}
+	`)
+	testCompileFailure(t, "Constant 256 does not fit in U8", `
+		var a U8 = 200 + 56
+	`)
+	testCompileFailure(t, "Constant -1 does not fit in U32", `
+		fn f(x U32)
+		fn g() {
+			f(-1)
+		}
+	`)
+	testCompileFailure(t, "Constant 70000 does not fit in I16", `
+		const k I16 = 70000
+	`)
+
+	// Literals above the range of I64 only fit in U64
+	testCompile(t, `
+		const k = 1 << 63
+		var a U64 = 18446744073709551615
+		var b U64 = k
+		var c I64 = -9223372036854775808
+		var d F64 = 18446744073709551615
+	`, `
+		data $a = align 8 { l -1 }
+		data $b = align 8 { l -9223372036854775808 }
+		data $c = align 8 { l -9223372036854775808 }
+		data $d = align 8 { d d_1.8446744073709552e+19 }
+	`)
+	testCompileFailure(t, "Constant 9223372036854775808 does not fit in I64", `
+		var x I64 = 9223372036854775808
+	`)
+	testCompileFailure(t, "Constant 18446744073709551615 does not fit in I64", `
+		var y = 18446744073709551615
+	`)
+	testCompileFailure(t, "Constant -18446744073709551615 is out of range", `
+		var w I32 = -18446744073709551615
+	`)
+	testCompileFailure(t, "Constant 9223372036854775808 does not fit in I64", `
+		fn f() {
+			var z I64 = 1 << 63
+		}
+	`)
+	testCompileFailure(t, "Constant 18446744073709551616 is out of range", `
+		const k = 18446744073709551615 + 1
+	`)
+	testCompileFailure(t, "Constant 18446744073709551615 does not fit in I64", `
+		fn f(x I64) I64 {
+			return x + 18446744073709551615
+		}
+	`)
+
+	// Constant floats are truncated when converted to integers, and must fit once truncated
+	testCompile(t, `
+		var a = cast(255.9, U8)
+		var b = cast(-128.7, I8)
+		var c = cast(-0.5, U32)
+	`, `
+		data $a = align 1 { b 255 }
+		data $b = align 1 { b -128 }
+		data $c = align 4 { w 0 }
+	`)
+	testCompileFailure(t, "Constant 1e+20 does not fit in I32", `
+		var x = cast(1e20, I32)
+	`)
+	testCompileFailure(t, "Constant 256 does not fit in U8", `
+		var x = cast(256.0, U8)
+	`)
+	testCompileFailure(t, "Constant -1.5 does not fit in U64", `
+		fn f() U64 {
+			return cast(-1.5, U64)
+		}
+	`)
+
+	parseFailure := func(code, err string) {
+		if _, e := Parse(code); e == nil || e.Error() != "Parse error at line 1: "+err {
+			t.Error("Incorrect error:", e)
+		}
+	}
+	parseFailure("var a = 300u8", "Integer literal 300u8 does not fit in U8")
+	parseFailure("var a = 128i8", "Integer literal 128i8 does not fit in I8")
+	parseFailure("var a = 1e39f32", "Float literal 1e39f32 does not fit in F32")
+	parseFailure("var a = 99999999999999999999", "Integer literal 99999999999999999999 is out of range")
+	parseFailure("var a = 1e400", "Float literal 1e400 is out of range")
 }
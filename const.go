@@ -0,0 +1,501 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Constant is the value of an expression evaluated at compile time
+type Constant struct {
+	Ty    Type
+	Int   int64   // Value of integer constants, truncated to the width of Ty
+	Float float64 // Value of floating-point constants
+	Huge  bool    // Untyped integer constant above the range of I64, whose value is uint64(Int)
+}
+
+// ConstExpression is an expression that can be evaluated at compile time
+type ConstExpression interface {
+	Expression
+	// Evaluate the expression, returning false if its value is not known at compile time
+	Const(c *Compiler) (Constant, bool)
+}
+
+func constValue(c *Compiler, e Expression) (Constant, bool) {
+	if e, ok := e.(ConstExpression); ok {
+		return e.Const(c)
+	}
+	return Constant{}, false
+}
+
+func (k Constant) Operand() string {
+	return k.IR().Operand()
+}
+
+// IR returns the constant as an IR literal
+func (k Constant) IR() Operand {
+	if isFloat(k.Ty) {
+		f := strconv.FormatFloat(k.Float, 'g', -1, 64)
+		return IRFloat{k.Ty.Concrete().IRBaseTypeName(), f}
+	}
+	return IRInteger(strconv.FormatInt(k.Int, 10))
+}
+
+func (k Constant) String() string {
+	switch {
+	case isFloat(k.Ty):
+		return strconv.FormatFloat(k.Float, 'g', -1, 64)
+	case k.Huge || !k.signed():
+		return strconv.FormatUint(uint64(k.Int), 10)
+	}
+	return strconv.FormatInt(k.Int, 10)
+}
+
+// signed returns true if the integer value of the constant is signed
+func (k Constant) signed() bool {
+	if _, ok := k.Ty.(IntLitType); ok {
+		return !k.Huge
+	}
+	return signed(k.Ty)
+}
+
+// bigInt returns the exact value of an integer constant
+func (k Constant) bigInt() *big.Int {
+	if k.signed() {
+		return big.NewInt(k.Int)
+	}
+	return new(big.Int).SetUint64(uint64(k.Int))
+}
+
+// untypedInt returns an untyped integer constant with the value v, panicking if it is out of range
+func untypedInt(v *big.Int) Constant {
+	switch {
+	case v.IsInt64():
+		return Constant{Ty: IntLitType{}, Int: v.Int64()}
+	case v.IsUint64():
+		return Constant{Ty: IntLitType{}, Int: int64(v.Uint64()), Huge: true}
+	}
+	panic("Constant " + v.String() + " is out of range")
+}
+
+// Convert converts the constant to another numeric type
+func (k Constant) Convert(ty Type) Constant {
+	v := Constant{Ty: ty}
+	switch {
+	case ty.Equals(IntLitType{}) && !isFloat(k.Ty):
+		v.Int, v.Huge = k.Int, k.Huge
+	case ty.IsConcrete() && ty.Concrete().Equals(TypeBool):
+		v.Int = boolInt(k.bool())
+	case isFloat(k.Ty) && isFloat(ty):
+		v.Float = k.Float
+	case isFloat(k.Ty):
+		// Converting a float outside the range of the integer type has no defined result
+		bits := 8 * ty.Concrete().Metrics().Size
+		lo, hi := -math.Ldexp(1, bits-1), math.Ldexp(1, bits-1)
+		if !signed(ty) {
+			lo, hi = 0, math.Ldexp(1, bits)
+		}
+		if f := math.Trunc(k.Float); !(f >= lo && f < hi) {
+			panic("Constant " + k.String() + " does not fit in " + ty.Format(0))
+		}
+		if signed(ty) {
+			v.Int = int64(k.Float)
+		} else {
+			v.Int = int64(uint64(k.Float))
+		}
+	case isFloat(ty):
+		if k.signed() {
+			v.Float = float64(k.Int)
+		} else {
+			v.Float = float64(uint64(k.Int))
+		}
+	default:
+		v.Int = k.Int
+	}
+	return v.truncate()
+}
+
+// truncate wraps the value of the constant to the range of its type
+func (k Constant) truncate() Constant {
+	if !k.Ty.IsConcrete() {
+		return k
+	}
+	if k.Ty.Concrete().Equals(TypeF32) {
+		k.Float = float64(float32(k.Float))
+	}
+	if isFloat(k.Ty) {
+		return k
+	}
+
+	bits := 8 * uint(k.Ty.Concrete().Metrics().Size)
+	if bits < 64 {
+		if signed(k.Ty) {
+			k.Int = k.Int << (64 - bits) >> (64 - bits)
+		} else {
+			k.Int &= 1<<bits - 1
+		}
+	}
+	return k
+}
+
+func (k Constant) bool() bool {
+	if isFloat(k.Ty) {
+		return k.Float != 0
+	}
+	return k.Int != 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// signed returns true if ty is a signed numeric type
+func signed(ty Type) bool {
+	n, ok := ty.Concrete().(NumericType)
+	return ok && n.Signed()
+}
+
+func (e BinaryExpr) Const(c *Compiler) (Constant, bool) {
+	l, lok := constValue(c, e.L)
+	r, rok := constValue(c, e.R)
+	if !lok || !rok {
+		return Constant{}, false
+	}
+
+	ty := e.operandType(c)
+	if _, ok := ty.Concrete().(PointerType); ok {
+		return Constant{}, false
+	}
+	l, r = l.Convert(ty), r.Convert(ty)
+	v := Constant{Ty: e.TypeOf(c)}
+	if _, ok := ty.(IntLitType); ok {
+		return e.untypedConst(l, r, v.Ty), true
+	}
+
+	if isFloat(ty) {
+		switch e.Op {
+		case BinAdd:
+			v.Float = l.Float + r.Float
+		case BinSub:
+			v.Float = l.Float - r.Float
+		case BinMul:
+			v.Float = l.Float * r.Float
+		case BinDiv:
+			v.Float = l.Float / r.Float
+
+		case BinCeq:
+			v.Int = boolInt(l.Float == r.Float)
+		case BinCne:
+			v.Int = boolInt(l.Float != r.Float)
+		case BinClt:
+			v.Int = boolInt(l.Float < r.Float)
+		case BinCgt:
+			v.Int = boolInt(l.Float > r.Float)
+		case BinCle:
+			v.Int = boolInt(l.Float <= r.Float)
+		case BinCge:
+			v.Int = boolInt(l.Float >= r.Float)
+		}
+		return v.truncate(), true
+	}
+
+	if (e.Op == BinDiv || e.Op == BinMod) && r.Int == 0 {
+		panic("Division by zero in constant expression")
+	}
+
+	sgn := signed(ty)
+	ul, ur := uint64(l.Int), uint64(r.Int)
+	switch e.Op {
+	case BinAdd:
+		v.Int = l.Int + r.Int
+	case BinSub:
+		v.Int = l.Int - r.Int
+	case BinMul:
+		v.Int = l.Int * r.Int
+	case BinDiv:
+		if sgn {
+			v.Int = l.Int / r.Int
+		} else {
+			v.Int = int64(ul / ur)
+		}
+	case BinMod:
+		if sgn {
+			v.Int = l.Int % r.Int
+		} else {
+			v.Int = int64(ul % ur)
+		}
+
+	case BinOr:
+		v.Int = l.Int | r.Int
+	case BinXor:
+		v.Int = l.Int ^ r.Int
+	case BinAnd:
+		v.Int = l.Int & r.Int
+	case BinShl:
+		v.Int = l.Int << ur
+	case BinShr:
+		if sgn {
+			v.Int = l.Int >> ur
+		} else {
+			v.Int = int64(ul >> ur)
+		}
+
+	case BinCeq:
+		v.Int = boolInt(l.Int == r.Int)
+	case BinCne:
+		v.Int = boolInt(l.Int != r.Int)
+	case BinClt:
+		v.Int = boolInt(sgn && l.Int < r.Int || !sgn && ul < ur)
+	case BinCgt:
+		v.Int = boolInt(sgn && l.Int > r.Int || !sgn && ul > ur)
+	case BinCle:
+		v.Int = boolInt(sgn && l.Int <= r.Int || !sgn && ul <= ur)
+	case BinCge:
+		v.Int = boolInt(sgn && l.Int >= r.Int || !sgn && ul >= ur)
+	}
+	return v.truncate(), true
+}
+
+// untypedConst evaluates the expression exactly, for untyped integer operands
+func (e BinaryExpr) untypedConst(l, r Constant, ty Type) Constant {
+	if (e.Op == BinDiv || e.Op == BinMod) && r.Int == 0 && !r.Huge {
+		panic("Division by zero in constant expression")
+	}
+
+	a, b := l.bigInt(), r.bigInt()
+	v := new(big.Int)
+	switch e.Op {
+	case BinAdd:
+		v.Add(a, b)
+	case BinSub:
+		v.Sub(a, b)
+	case BinMul:
+		v.Mul(a, b)
+	case BinDiv:
+		v.Quo(a, b)
+	case BinMod:
+		v.Rem(a, b)
+
+	case BinOr:
+		v.Or(a, b)
+	case BinXor:
+		v.Xor(a, b)
+	case BinAnd:
+		v.And(a, b)
+	case BinShl, BinShr:
+		if b.Sign() < 0 || b.Cmp(big.NewInt(128)) > 0 {
+			panic("Shift count " + b.String() + " is out of range")
+		}
+		if e.Op == BinShl {
+			v.Lsh(a, uint(b.Int64()))
+		} else {
+			v.Rsh(a, uint(b.Int64()))
+		}
+
+	default:
+		cmp := a.Cmp(b)
+		var res bool
+		switch e.Op {
+		case BinCeq:
+			res = cmp == 0
+		case BinCne:
+			res = cmp != 0
+		case BinClt:
+			res = cmp < 0
+		case BinCgt:
+			res = cmp > 0
+		case BinCle:
+			res = cmp <= 0
+		case BinCge:
+			res = cmp >= 0
+		}
+		return Constant{Ty: ty, Int: boolInt(res)}
+	}
+	return untypedInt(v)
+}
+
+func (e PrefixExpr) Const(c *Compiler) (Constant, bool) {
+	v, ok := constValue(c, e.V)
+	if !ok {
+		return Constant{}, false
+	}
+
+	if e.Op == PrefNot {
+		return Constant{Ty: e.TypeOf(c), Int: boolInt(!v.bool())}, true
+	}
+
+	v = v.Convert(e.TypeOf(c))
+	if _, ok := v.Ty.(IntLitType); ok {
+		switch e.Op {
+		case PrefInv:
+			return untypedInt(new(big.Int).Not(v.bigInt())), true
+		case PrefNeg:
+			return untypedInt(new(big.Int).Neg(v.bigInt())), true
+		}
+		return v, true
+	}
+	switch e.Op {
+	case PrefInv:
+		v.Int = ^v.Int
+	case PrefNeg:
+		v.Int = -v.Int
+		v.Float = -v.Float
+	case PrefPos:
+	}
+	return v.truncate(), true
+}
+
+func (e CastExpr) Const(c *Compiler) (Constant, bool) {
+	v, ok := constValue(c, e.V)
+	if !ok {
+		return Constant{}, false
+	}
+	return v.Convert(e.TypeOf(c)), true
+}
+
+// Only bitcasts to integers are evaluated, since the bits of a float may not have a literal representation
+func (e BitcastExpr) Const(c *Compiler) (Constant, bool) {
+	v, ok := constValue(c, e.V)
+	ty := e.TypeOf(c)
+	if !ok || isFloat(ty) {
+		return Constant{}, false
+	}
+
+	k := Constant{Ty: ty, Int: v.Int}
+	if isFloat(v.Ty) {
+		if v.Ty.Concrete().Equals(TypeF32) {
+			k.Int = int64(math.Float32bits(float32(v.Float)))
+		} else {
+			k.Int = int64(math.Float64bits(v.Float))
+		}
+	}
+	return k.truncate(), true
+}
+
+func (e CallExpr) Const(c *Compiler) (Constant, bool) {
+	if b, ok := e.builtin(c); ok {
+		return constValue(c, b)
+	}
+	return Constant{}, false
+}
+
+func (e LenExpr) Const(c *Compiler) (Constant, bool) {
+	if a, ok := undecayedType(c, e.V).Concrete().(ArrayType); ok {
+		return Constant{Ty: TypeU64, Int: int64(a.N)}, true
+	}
+	return Constant{}, false
+}
+
+func (e SizeofExpr) Const(c *Compiler) (Constant, bool) {
+	return Constant{Ty: TypeU64, Int: int64(e.Ty.Get(c).Metrics().Size)}, true
+}
+func (e AlignofExpr) Const(c *Compiler) (Constant, bool) {
+	return Constant{Ty: TypeU64, Int: int64(e.Ty.Get(c).Metrics().Align)}, true
+}
+func (e OffsetofExpr) Const(c *Compiler) (Constant, bool) {
+	ty := e.Ty.Get(c)
+	comp, ok := ty.Concrete().(CompositeType)
+	if !ok {
+		panic("offsetof used on non-composite type " + ty.Format(0))
+	}
+	if comp.Field(e.Field) == nil {
+		panic("No such field: " + e.Field)
+	}
+	return Constant{Ty: TypeU64, Int: int64(comp.Offset(e.Field))}, true
+}
+
+func (e VarExpr) Const(c *Compiler) (Constant, bool) {
+	k, ok := c.Variable(string(e)).Loc.(Constant)
+	return k, ok
+}
+func (e AccessExpr) Const(c *Compiler) (Constant, bool) {
+	if ns, ok := e.L.TypeOf(c).(Namespace); ok {
+		k, ok := c.nsMember(ns, e.R).Loc.(Constant)
+		return k, ok
+	}
+	return Constant{}, false
+}
+
+func (e IntegerExpr) Const(c *Compiler) (Constant, bool) {
+	return e.value(), true
+}
+func (e FloatExpr) Const(c *Compiler) (Constant, bool) {
+	return e.value(), true
+}
+
+// value returns the value of the literal, typed according to its suffix
+func (e IntegerExpr) value() Constant {
+	s, suffix := splitSuffix(string(e), "iu")
+	i, err := strconv.ParseUint(strings.ReplaceAll(s, "_", ""), 0, 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			panic("Integer literal " + string(e) + " is out of range")
+		}
+		panic("Invalid integer literal " + string(e))
+	}
+
+	k := Constant{Ty: IntLitType{}, Int: int64(i), Huge: i > math.MaxInt64}
+	if suffix != "" {
+		ty := literalSuffixes[suffix]
+		if k.Convert(ty).Int != k.Int || ty.Signed() && k.Huge {
+			panic("Integer literal " + string(e) + " does not fit in " + ty.Format(0))
+		}
+		k.Ty, k.Huge = ty, false
+	}
+	return k
+}
+func (e FloatExpr) value() Constant {
+	s, suffix := splitSuffix(string(e), "f")
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			panic("Float literal " + string(e) + " is out of range")
+		}
+		panic("Invalid float literal " + string(e))
+	}
+
+	k := Constant{Ty: FloatLitType{}, Float: f}
+	if suffix != "" {
+		ty := literalSuffixes[suffix]
+		if math.IsInf(k.Convert(ty).Float, 0) {
+			panic("Float literal " + string(e) + " does not fit in " + ty.Format(0))
+		}
+		k.Ty = ty
+	}
+	return k
+}
+
+// checkRange panics if k is an untyped integer constant whose value does not fit in ty
+func (k Constant) checkRange(ty Type) {
+	if _, ok := k.Ty.(IntLitType); !ok || !ty.IsConcrete() || isFloat(ty) {
+		return
+	}
+	if _, ok := ty.Concrete().(NumericType); !ok {
+		return
+	}
+	// Values above the range of I64 only fit in U64
+	if k.Convert(ty).Int != k.Int || k.Huge && signed(ty) {
+		panic("Constant " + k.String() + " does not fit in " + ty.Format(0))
+	}
+}
+
+// checkRange panics if e is an untyped integer constant whose value does not fit in ty
+func checkRange(c *Compiler, e Expression, ty Type) {
+	if !ty.IsConcrete() {
+		return
+	}
+	// Check the type first, since evaluating constants is expensive
+	if _, ok := e.TypeOf(c).(IntLitType); !ok {
+		return
+	}
+	if k, ok := constValue(c, e); ok {
+		k.checkRange(ty)
+	}
+}
+func (e RuneExpr) Const(c *Compiler) (Constant, bool) {
+	return Constant{Ty: IntLitType{}, Int: int64(e)}, true
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 	"unicode/utf8"
 )
@@ -40,18 +39,12 @@ func (f Function) Format(indent int) string {
 		b.WriteString("pub ")
 	}
 	b.WriteString("fn ")
-	b.WriteString(f.Name)
-
-	b.WriteByte('(')
-	for i, param := range f.Param {
-		if i > 0 {
-			b.WriteString(", ")
-		}
-		b.WriteString(param.Name)
-		b.WriteByte(' ')
-		b.WriteString(param.Ty.Format(indent))
+	if f.Recv != nil {
+		b.WriteString("(" + f.Recv.Name + " " + f.Recv.Ty.Format(indent) + ") ")
 	}
-	b.WriteByte(')')
+	b.WriteString(f.Name)
+	b.WriteString(fmtTypeParams(f.TypeParam))
+	b.WriteString(fmtParams(indent, f.Param))
 
 	if f.Ret != nil {
 		b.WriteByte(' ')
@@ -68,16 +61,42 @@ func (d VarDecl) Format(indent int) string {
 	return "var " + d.Name + " " + d.Ty.Format(indent)
 }
 func (d VarsDecl) Format(indent int) string {
-	return "var " + strings.Join(d.Names, ", ") + " " + d.Ty.Format(indent)
+	s := "var " + strings.Join(d.Names, ", ")
+	if d.Ty != nil {
+		s += " " + d.Ty.Format(indent)
+	}
+	if d.Init != nil {
+		s += " = " + fmtList(indent, d.Init)
+	}
+	return s
+}
+
+func (d ConstDecl) Format(indent int) string {
+	s := "const " + d.Name
+	if d.Ty != nil {
+		s += " " + d.Ty.Format(indent)
+	}
+	return s + " = " + d.Value.Format(indent)
 }
 
 func (t TypeDef) Format(indent int) string {
-	return "type " + t.Name + " " + t.Ty.Format(indent)
+	return "type " + t.Name + fmtTypeParams(t.TypeParam) + " " + t.Ty.Format(indent)
+}
+
+func fmtTypeParams(params []string) string {
+	if params == nil {
+		return ""
+	}
+	return "[" + strings.Join(params, ", ") + "]"
 }
 func (t TypeAlias) Format(indent int) string {
 	return "type " + t.Name + " = " + t.Ty.Format(indent)
 }
 
+func (b BlockStmt) Format(indent int) string {
+	return fmtBlock(indent, b)
+}
+
 func (i IfStmt) Format(indent int) string {
 	s := "if " + i.Cond.Format(indent) + " " + fmtBlock(indent, i.Then)
 	if i.Else != nil {
@@ -86,6 +105,30 @@ func (i IfStmt) Format(indent int) string {
 	return s
 }
 
+func (s SwitchStmt) Format(indent int) string {
+	b := &strings.Builder{}
+	b.WriteString("switch ")
+	b.WriteString(s.Value.Format(indent))
+	b.WriteString(" {")
+	for _, sc := range s.Cases {
+		b.WriteString(newLine(indent))
+		if sc.Values == nil {
+			b.WriteString("default:")
+		} else {
+			b.WriteString("case ")
+			b.WriteString(fmtList(indent, sc.Values))
+			b.WriteByte(':')
+		}
+		for _, stmt := range sc.Body {
+			b.WriteString(newLine(indent + 1))
+			b.WriteString(stmt.Format(indent + 1))
+		}
+	}
+	b.WriteString(newLine(indent))
+	b.WriteByte('}')
+	return b.String()
+}
+
 func (f ForStmt) Format(indent int) string {
 	b := &strings.Builder{}
 	b.WriteString("for ")
@@ -123,9 +166,43 @@ func (r ReturnStmt) Format(indent int) string {
 	return "return " + r.Value.Format(indent)
 }
 
+func (s AssignStmt) Format(indent int) string {
+	l := make([]Expression, len(s.L))
+	for i, e := range s.L {
+		l[i] = e
+	}
+	return fmtList(indent, l) + " = " + fmtList(indent, s.R)
+}
+
 func (e AccessExpr) Format(indent int) string {
 	return e.L.Format(indent) + "." + e.R
 }
+func (e IndexExpr) Format(indent int) string {
+	return e.V.Format(indent) + "[" + e.I.Format(indent) + "]"
+}
+func (e SliceExpr) Format(indent int) string {
+	s := e.V.Format(indent) + "["
+	if e.Lo != nil {
+		s += e.Lo.Format(indent)
+	}
+	s += ".."
+	if e.Hi != nil {
+		s += e.Hi.Format(indent)
+	}
+	return s + "]"
+}
+func (e LenExpr) Format(indent int) string {
+	return "len(" + e.V.Format(indent) + ")"
+}
+func (e SizeofExpr) Format(indent int) string {
+	return "sizeof(" + e.Ty.Format(indent) + ")"
+}
+func (e AlignofExpr) Format(indent int) string {
+	return "alignof(" + e.Ty.Format(indent) + ")"
+}
+func (e OffsetofExpr) Format(indent int) string {
+	return "offsetof(" + e.Ty.Format(indent) + ", " + e.Field + ")"
+}
 func (e AssignExpr) Format(indent int) string {
 	return "(" + e.L.Format(indent) + " = " + e.R.Format(indent) + ")"
 }
@@ -134,17 +211,41 @@ func (e MutateExpr) Format(indent int) string {
 }
 
 func (e CallExpr) Format(indent int) string {
-	args := make([]string, len(e.Args))
-	for i, arg := range e.Args {
-		args[i] = arg.Format(indent)
-	}
-	return e.Func.Format(indent) + "(" + strings.Join(args, ", ") + ")"
+	return e.Func.Format(indent) + "(" + fmtList(indent, e.Args) + ")"
 }
 
 func (e CastExpr) Format(indent int) string {
 	return "cast(" + e.V.Format(0) + ", " + e.Ty.Format(0) + ")"
 }
+func (e BitcastExpr) Format(indent int) string {
+	return "bitcast(" + e.V.Format(0) + ", " + e.Ty.Format(0) + ")"
+}
 
+func (e FuncExpr) Format(indent int) string {
+	b := &strings.Builder{}
+	b.WriteString("fn")
+	b.WriteString(fmtParams(indent, e.Param))
+	if e.Ret != nil {
+		b.WriteByte(' ')
+		b.WriteString(e.Ret.Format(indent))
+	}
+	b.WriteByte(' ')
+	b.WriteString(fmtBlock(indent, e.Body))
+	return b.String()
+}
+func (e InstanceExpr) Format(indent int) string {
+	return e.Name
+}
+func (e GenericExpr) Format(indent int) string {
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.Format(indent)
+	}
+	return e.Func.Format(indent) + "[" + strings.Join(args, ", ") + "]"
+}
+func (e MethodExpr) Format(indent int) string {
+	return e.Ty.Name + "." + e.Name
+}
 func (e VarExpr) Format(indent int) string {
 	return string(e)
 }
@@ -167,6 +268,19 @@ func (e BooleanExpr) Format(indent int) string {
 	return fmt.Sprintf("(%s %s %s)", e.L.Format(indent), e.Op, e.R.Format(indent))
 }
 
+func (e InitExpr) Format(indent int) string {
+	return "{" + fmtList(indent, e) + "}"
+}
+func (e TupleExpr) Format(indent int) string {
+	return fmtList(indent, e)
+}
+func (e KeyedExpr) Format(indent int) string {
+	return e.Name + ": " + e.V.Format(indent)
+}
+func (e CompositeExpr) Format(indent int) string {
+	return e.Ty.Format(indent) + e.Init.Format(indent)
+}
+
 func (e IntegerExpr) Format(indent int) string {
 	return string(e)
 }
@@ -223,11 +337,38 @@ func (name NamedTypeExpr) Format(indent int) string {
 func (ns NamespaceTypeExpr) Format(indent int) string {
 	return strings.Join([]string(ns), ".")
 }
+func (g GenericTypeExpr) Format(indent int) string {
+	args := make([]string, len(g.Args))
+	for i, arg := range g.Args {
+		args[i] = arg.Format(indent)
+	}
+	return g.Name.Format(indent) + "[" + strings.Join(args, ", ") + "]"
+}
 func (ptr PointerTypeExpr) Format(indent int) string {
-	return "[" + ptr.To.Format(indent) + "]"
+	var to string
+	if ptr.Const {
+		to = "const "
+	}
+	if ptr.To != nil {
+		to += ptr.To.Format(indent)
+	}
+	return "[" + strings.TrimSpace(to) + "]"
 }
 func (arr ArrayTypeExpr) Format(indent int) string {
-	return arr.Ty.Format(indent) + " " + strconv.Itoa(arr.N)
+	return "[" + arr.Ty.Format(indent) + " " + arr.N.Format(indent) + "]"
+}
+func (t TupleTypeExpr) Format(indent int) string {
+	types := make([]string, len(t))
+	for i, ty := range t {
+		types[i] = ty.Format(indent)
+	}
+	return "(" + strings.Join(types, ", ") + ")"
+}
+func (s SliceTypeExpr) Format(indent int) string {
+	if s.Const {
+		return "[const " + s.Ty.Format(indent) + " ..]"
+	}
+	return "[" + s.Ty.Format(indent) + " ..]"
 }
 func (fun FuncTypeExpr) Format(indent int) string {
 	params := make([]string, len(fun.Param))
@@ -240,11 +381,97 @@ func (fun FuncTypeExpr) Format(indent int) string {
 	}
 	return "fn(" + strings.Join(params, ", ") + ")" + ret
 }
+func (s MatchStmt) Format(indent int) string {
+	b := &strings.Builder{}
+	b.WriteString("match ")
+	b.WriteString(s.Value.Format(indent))
+	b.WriteString(" {")
+	for _, mc := range s.Cases {
+		b.WriteString(newLine(indent))
+		if mc.Variant == "" {
+			b.WriteString("default:")
+		} else {
+			b.WriteString("case ")
+			b.WriteString(mc.Variant)
+			if mc.Bind != "" {
+				b.WriteString("(" + mc.Bind + ")")
+			}
+			b.WriteByte(':')
+		}
+		for _, stmt := range mc.Body {
+			b.WriteString(newLine(indent + 1))
+			b.WriteString(stmt.Format(indent + 1))
+		}
+	}
+	b.WriteString(newLine(indent))
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (e EnumTypeExpr) Format(indent int) string {
+	members := make([]string, len(e.Members))
+	for i, m := range e.Members {
+		members[i] = m.Name
+		if m.Value != nil {
+			members[i] += " = " + m.Value.Format(indent)
+		}
+	}
+	return "enum " + e.Ty.Format(indent) + " {" + strings.Join(members, ", ") + "}"
+}
 func (s StructTypeExpr) Format(indent int) string {
-	return s.Get(nil).Format(indent)
+	return "struct " + fmtComposite(indent, s)
 }
 func (u UnionTypeExpr) Format(indent int) string {
-	return u.Get(nil).Format(indent)
+	return "union " + fmtComposite(indent, u)
+}
+func (v VariantTypeExpr) Format(indent int) string {
+	return "variant " + fmtComposite(indent, v)
+}
+
+func (iface InterfaceTypeExpr) Format(indent int) string {
+	b := &strings.Builder{}
+	b.WriteString("interface {")
+	for _, m := range iface {
+		b.WriteString(newLine(indent + 1))
+		b.WriteString(m.Name)
+		b.WriteString(fmtParams(indent+1, m.Param))
+		if m.Ret != nil {
+			b.WriteByte(' ')
+			b.WriteString(m.Ret.Format(indent + 1))
+		}
+	}
+	b.WriteString(newLine(indent))
+	b.WriteByte('}')
+	return b.String()
+}
+
+func fmtParams(indent int, params []VarDecl) string {
+	b := &strings.Builder{}
+	b.WriteByte('(')
+	for i, param := range params {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(param.Name)
+		b.WriteByte(' ')
+		b.WriteString(param.Ty.Format(indent))
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+func fmtComposite(indent int, fields []VarDecl) string {
+	b := &strings.Builder{}
+	b.WriteByte('{')
+	for _, field := range fields {
+		b.WriteString(newLine(indent + 1))
+		b.WriteString(field.Name)
+		b.WriteByte(' ')
+		b.WriteString(field.Ty.Format(indent + 1))
+	}
+	b.WriteString(newLine(indent))
+	b.WriteByte('}')
+	return b.String()
 }
 
 func fmtBlock(indent int, body []Statement) string {
@@ -259,6 +486,14 @@ func fmtBlock(indent int, body []Statement) string {
 	return b.String()
 }
 
+func fmtList(indent int, exprs []Expression) string {
+	strs := make([]string, len(exprs))
+	for i, e := range exprs {
+		strs[i] = e.Format(indent)
+	}
+	return strings.Join(strs, ", ")
+}
+
 func newLine(indent int) string {
 	b := make([]byte, indent+1)
 	for indent > 0 {
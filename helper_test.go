@@ -1,6 +1,13 @@
 // Helpers for tests, not tests for helpers
 package main
 
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
 func spc(ch byte) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n'
 }
@@ -45,3 +52,45 @@ func CodeCompare(a, b string) (eq bool, ai, bi int) {
 		bi++
 	}
 }
+
+// testRun compiles a program, links it with some C code and checks the output of running it
+// The test is skipped if qbe is not available
+func testRun(t *testing.T, code, csrc, output string) {
+	if _, err := exec.LookPath("qbe"); err != nil {
+		t.Skip("qbe not found")
+	}
+
+	prog, err := Parse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewCompiler().Compile(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	ssa := filepath.Join(dir, "prog.ssa")
+	asm := filepath.Join(dir, "prog.s")
+	cfile := filepath.Join(dir, "lib.c")
+	exe := filepath.Join(dir, "prog")
+	if err := ioutil.WriteFile(ssa, []byte(r.String()), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cfile, []byte(csrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(name string, args ...string) []byte {
+		out, err := exec.Command(name, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s failed: %s\n%s", name, err, out)
+		}
+		return out
+	}
+	run("qbe", "-o", asm, ssa)
+	run("cc", "-o", exe, asm, cfile)
+	if out := string(run(exe)); out != output {
+		t.Errorf("Output does not match: expected\n%s\ngot\n%s", output, out)
+	}
+}
@@ -18,16 +18,22 @@ type lexer struct {
 }
 
 func (l *lexer) tokenize(code string) {
-	for _, m := range lexerRegex.FindAllStringSubmatchIndex(code, -1) {
-		m = m[2:]
+	for off := 0; off < len(code); {
+		m := lexerRegex.FindStringSubmatchIndex(code[off:])[2:]
 		for i, rule := range lexerRules {
 			a, b := m[2*i], m[2*i+1]
 			if a >= 0 && b >= 0 {
+				a, b = off+a, off+b
 				tok := Token{a, rule.Ty, code[a:b]}
-				if rule.Sub != nil {
+				if rule.Ty == TFloat && strings.HasSuffix(tok.S, ".") && strings.HasPrefix(code[b:], ".") {
+					// An integer followed by '..', not a float
+					b--
+					tok = parseInt(Token{a, TInteger, code[a:b]})
+				} else if rule.Sub != nil {
 					tok = rule.Sub(tok)
 				}
 				l.emitToken(tok)
+				off = b
 				break
 			}
 		}
@@ -78,15 +84,50 @@ func parseString(tok Token) Token {
 	tok.S = b.String()
 	return tok
 }
+
+// parseInt converts an integer literal to decimal, keeping its type suffix
+// Literals that do not fit in 64 bits are left as is, and rejected by the parser
+// Range checks against the literal's type happen when it is used
 func parseInt(tok Token) Token {
-	i, _ := strconv.ParseInt(tok.S, 0, 64)
-	tok.S = strconv.FormatInt(i, 10)
+	s, suffix := splitSuffix(tok.S, "iu")
+	if i, err := strconv.ParseUint(strings.ReplaceAll(s, "_", ""), 0, 64); err == nil {
+		tok.S = strconv.FormatUint(i, 10) + suffix
+	}
 	return tok
 }
+
+// parseFloat removes digit separators from a float literal, and converts hex floats to decimal
 func parseFloat(tok Token) Token {
+	s, suffix := splitSuffix(tok.S, "f")
+	s = strings.ReplaceAll(s, "_", "")
+	if strings.Contains(s, "0x") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return tok
+		}
+		s = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	tok.S = s + suffix
 	return tok
 }
 
+// splitSuffix splits a numeric literal into its digits and type suffix, which starts with one of kinds
+func splitSuffix(s, kinds string) (digits, suffix string) {
+	if i := strings.LastIndexAny(s, kinds); i >= 0 {
+		if _, ok := literalSuffixes[s[i:]]; ok {
+			return s[:i], s[i:]
+		}
+	}
+	return s, ""
+}
+
+// The types given to numeric literals by their suffixes
+var literalSuffixes = map[string]PrimitiveType{
+	"i64": TypeI64, "i32": TypeI32, "i16": TypeI16, "i8": TypeI8,
+	"u64": TypeU64, "u32": TypeU32, "u16": TypeU16, "u8": TypeU8,
+	"f64": TypeF64, "f32": TypeF32,
+}
+
 var stringRegex = regexp.MustCompile(`\\([enrt\\"'])|\\([0-7]{3})|\\x([a-fA-F0-9]{2})|\\(u[a-fA-F0-9]{4}|U[a-fA-F0-9]{8})|(\\.)|(.)`)
 var stringRules = []func(string) string{
 	func(m string) string {
@@ -172,12 +213,14 @@ func init() {
 			pat = `'(?:[^"\\]|\\[enrt\\']|\\[0-7]{3}|\\x[a-fA-F0-9]{2}|\\u[a-fA-F0-9]{4}|\\U[a-fA-F0-9]{8})'`
 			sub = parseString
 		case TInteger:
-			// TODO: type suffixes
-			pat = `(?:0x[0-9A-Fa-f_]+|0b[01_]+|0[0-7_]*|[0-9_]+)`
+			pat = `(?:0x[0-9A-Fa-f_]+|0b[01_]+|0[0-7_]*|[0-9_]+)(?:[iu](?:8|16|32|64))?`
 			sub = parseInt
 		case TFloat:
-			// TODO: type suffixes, hex floats(?), exponents
-			pat = `[-+]?(?:\d+\.\d*|\.\d+)`
+			pat = `[-+]?(?:` +
+				`0x(?:[0-9A-Fa-f_]+\.?[0-9A-Fa-f_]*|\.[0-9A-Fa-f_]+)p[-+]?[0-9_]+|` + // Hex float
+				`(?:\d[0-9_]*\.[0-9_]*|\.\d[0-9_]*)(?:[eE][-+]?[0-9_]+)?|` + // Decimal float, optionally with exponent
+				`\d[0-9_]*[eE][-+]?[0-9_]+` + // Integer with exponent
+				`)(?:f32|f64)?`
 			sub = parseFloat
 
 		case TInvalid:
@@ -279,6 +322,7 @@ const (
 	TCne  // '!='
 	TCle  // '<='
 	TCge  // '>='
+	TDots // '..'
 
 	// Single character operators
 	TEquals  // '='
@@ -294,6 +338,7 @@ const (
 	TLess    // '<'
 	TGreater // '>'
 	TDot     // '.'
+	TColon   // ':'
 
 	TInvalid // invalid token
 
@@ -303,22 +348,34 @@ const (
 
 	// Keywords
 	TKeywordStart
-	TKbreak    // 'break'
-	TKcast     // 'cast'
-	TKcontinue // 'continue'
-	TKelse     // 'else'
-	TKextern   // 'extern'
-	TKfn       // 'fn'
-	TKfor      // 'for'
-	TKif       // 'if'
-	TKns       // 'ns'
-	TKpub      // 'pub'
-	TKreturn   // 'return'
-	TKstruct   // 'struct'
-	TKtype     // 'type'
-	TKunion    // 'union'
-	TKvar      // 'var'
-	TKvariadic // 'variadic'
+	TKalignof   // 'alignof'
+	TKbitcast   // 'bitcast'
+	TKbreak     // 'break'
+	TKcase      // 'case'
+	TKcast      // 'cast'
+	TKconst     // 'const'
+	TKcontinue  // 'continue'
+	TKdefault   // 'default'
+	TKelse      // 'else'
+	TKenum      // 'enum'
+	TKextern    // 'extern'
+	TKfn        // 'fn'
+	TKfor       // 'for'
+	TKif        // 'if'
+	TKinterface // 'interface'
+	TKmatch     // 'match'
+	TKns        // 'ns'
+	TKoffsetof  // 'offsetof'
+	TKpub       // 'pub'
+	TKreturn    // 'return'
+	TKsizeof    // 'sizeof'
+	TKstruct    // 'struct'
+	TKswitch    // 'switch'
+	TKtype      // 'type'
+	TKunion     // 'union'
+	TKvar       // 'var'
+	TKvariant   // 'variant'
+	TKvariadic  // 'variadic'
 	TKeywordEnd
 )
 
@@ -105,3 +105,21 @@ func TestAutoSemi(t *testing.T) {
 		{116, TFloat, "0."}, {118, TSemi, "\n"}, {119, TKreturn, "return"}, {125, TSemi, "\n"},
 	})
 }
+
+func TestTokenizeRange(t *testing.T) {
+	testTokens(t, `a[0..1] a[.. 2.] 1...5`, []Token{
+		{0, TIdent, "a"}, {1, TLSquare, "["}, {2, TInteger, "0"}, {3, TDots, ".."},
+		{5, TInteger, "1"}, {6, TRSquare, "]"},
+		{8, TIdent, "a"}, {9, TLSquare, "["}, {10, TDots, ".."}, {13, TFloat, "2."},
+		{15, TRSquare, "]"},
+		{17, TInteger, "1"}, {18, TDots, ".."}, {20, TFloat, ".5"},
+	})
+}
+
+func TestTokenizeNumber(t *testing.T) {
+	testTokens(t, `10u8 0xffi16 1_000 1.5f32 1e9 2.5e-3f64 0x1p-3 1_0.2_5 1f`, []Token{
+		{0, TInteger, "10u8"}, {5, TInteger, "255i16"}, {13, TInteger, "1000"},
+		{19, TFloat, "1.5f32"}, {26, TFloat, "1e9"}, {30, TFloat, "2.5e-3f64"},
+		{40, TFloat, "0.125"}, {47, TFloat, "10.25"}, {55, TInteger, "1"}, {56, TIdent, "f"},
+	})
+}
@@ -19,6 +19,8 @@ func main() {
 	verbose := flag.Bool("v", false, "verbose output")
 	irOut := flag.Bool("i", false, "output intermediate representation of the program")
 	obj := flag.Bool("c", false, "output an object file")
+	noBounds := flag.Bool("nobounds", false, "disable runtime bounds checks on slices")
+	strictBool := flag.Bool("strictbool", false, "require Bool conditions")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -107,7 +109,10 @@ func main() {
 			}
 		}
 
-		if r, err := NewCompiler().Compile(prog); err != nil {
+		c := NewCompiler()
+		c.NoBoundsCheck = *noBounds
+		c.StrictBool = *strictBool
+		if r, err := c.Compile(prog); err != nil {
 			log.Fatal(err)
 		} else if *irOut {
 			r.WriteTo(os.Stdout)
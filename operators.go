@@ -47,3 +47,8 @@ const (
 
 	BooleanOperatorMax
 )
+
+// Compare returns true if the operator is a comparison
+func (op BinaryOperator) Compare() bool {
+	return op >= BinCeq
+}
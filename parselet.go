@@ -1,7 +1,5 @@
 package main
 
-import "strconv"
-
 type toplevelParselet func(*parser, Token) Toplevel
 type statementParselet func(*parser, Token) Statement
 type prefixExprParselet struct {
@@ -74,7 +72,19 @@ func init() {
 
 		TKfn: func(p *parser, tok Token) Toplevel {
 			// Parse function signature
+			var recv *VarDecl
+			if p.accept(TLParen) {
+				recv = &VarDecl{Name: p.require(TIdent).S, Ty: p.parseType()}
+				if recv.Ty == nil {
+					p.errExpect("type")
+				}
+				p.require(TRParen)
+			}
 			name := p.require(TIdent).S
+			var typeParams []string
+			if p.peek() == TLSquare {
+				typeParams = p.parseTypeParams()
+			}
 			p.require(TLParen)
 			var params []VarDecl
 			for l := p.list(TComma, TRParen); l.next(); {
@@ -82,34 +92,114 @@ func init() {
 			}
 			ret := p.parseType()
 
-			if p.peek() == TLBrace {
+			if recv != nil || typeParams != nil || p.peek() == TLBrace {
 				// Parse function body
-				return Function{false, name, params, ret, p.parseBlock()}
+				return Function{false, recv, name, typeParams, params, ret, p.parseBlock()}
 			} else {
 				// No body, just a declaration
 				paramTy := make([]TypeExpr, len(params))
 				for i, param := range params {
 					paramTy[i] = param.Ty
 				}
-				return VarsDecl{true, []string{name}, FuncTypeExpr{false, paramTy, ret}}
+				return VarsDecl{true, []string{name}, FuncTypeExpr{false, paramTy, ret}, nil}
 			}
 		},
 
 		TKvar: func(p *parser, tok Token) Toplevel {
-			return p.parseVarTypes()
+			return p.parseVarsDecl()
+		},
+
+		TKconst: func(p *parser, tok Token) Toplevel {
+			return p.parseConstDecl()
 		},
 
 		TKtype: func(p *parser, tok Token) Toplevel {
 			name := p.require(TType).S
 			if p.accept(TEquals) {
 				return TypeAlias{name, p.parseType()}
-			} else {
-				return TypeDef{name, p.parseType()}
 			}
+			if !p.accept(TLSquare) {
+				return TypeDef{name, nil, p.parseType()}
+			}
+			if p.accept(TKconst) {
+				return TypeDef{name, nil, p.parseConstPointer()}
+			}
+
+			// Either a list of type parameters or a pointer, slice or array type
+			ty := p.parseType()
+			param, isName := ty.(NamedTypeExpr)
+			if p.peek() == TComma && isName {
+				params := []string{string(param)}
+				for p.accept(TComma) {
+					params = append(params, p.require(TType).S)
+				}
+				p.require(TRSquare)
+				return TypeDef{name, params, p.parseType()}
+			}
+			ty = p.parseSquareType(ty)
+			if _, isType := typeParselets[p.peek()]; isType {
+				if ptr, ok := ty.(PointerTypeExpr); ok && isName && ptr.To == param {
+					return TypeDef{name, []string{string(param)}, p.parseType()}
+				}
+			}
+			return TypeDef{name, nil, ty}
 		},
 	}
 }
 
+// parseTypeParams parses the type parameters of a generic function
+func (p *parser) parseTypeParams() (params []string) {
+	p.require(TLSquare)
+	for l := p.list(TComma, TRSquare); l.next(); {
+		params = append(params, p.require(TType).S)
+	}
+	if len(params) == 0 {
+		p.errExpect("type parameter")
+	}
+	return
+}
+
+// parseTypeArgs parses the type arguments of a generic type, if there are any
+func (p *parser) parseTypeArgs(name TypeExpr) TypeExpr {
+	if !p.accept(TLSquare) {
+		return name
+	}
+	g := GenericTypeExpr{Name: name}
+	for l := p.list(TComma, TRSquare); l.next(); {
+		ty := p.parseType()
+		if ty == nil {
+			p.errExpect("type")
+		}
+		g.Args = append(g.Args, ty)
+	}
+	return g
+}
+
+// parseConstPointer parses the remainder of a const pointer or slice type, after the const keyword
+func (p *parser) parseConstPointer() TypeExpr {
+	to := p.parseType()
+	if p.accept(TDots) {
+		p.require(TRSquare)
+		return SliceTypeExpr{to, true}
+	}
+	p.require(TRSquare)
+	return PointerTypeExpr{to, true}
+}
+
+// parseSquareType parses the remainder of a pointer, slice or array type, after its element type
+func (p *parser) parseSquareType(to TypeExpr) TypeExpr {
+	if p.accept(TRSquare) {
+		return PointerTypeExpr{To: to}
+	}
+	if p.accept(TDots) {
+		p.require(TRSquare)
+		return SliceTypeExpr{Ty: to}
+	}
+	n := p.parseExpression(0)
+	p.require(TRSquare)
+	return ArrayTypeExpr{to, n}
+}
+
 func (p *parser) parseBlock() (stmts []Statement) {
 	p.require(TLBrace)
 	for l := p.list(TSemi, TRBrace); l.next(); {
@@ -118,12 +208,70 @@ func (p *parser) parseBlock() (stmts []Statement) {
 	return
 }
 
+// parseInitList parses the values of an initializer list, after the opening brace
+func (p *parser) parseInitList() InitExpr {
+	init := InitExpr{}
+	for l := p.list(TComma, TRBrace); l.next(); {
+		e := p.parseExpression(0)
+		if p.accept(TColon) {
+			name, ok := e.(VarExpr)
+			if !ok {
+				panic("Expected field name; got " + e.Format(0))
+			}
+			e = KeyedExpr{string(name), p.parseExpression(0)}
+		}
+		init = append(init, e)
+	}
+	return init
+}
+
+// parseCaseBody parses the statements following a case label
+func (p *parser) parseCaseBody() (stmts []Statement) {
+	for p.peek() != TKcase && p.peek() != TKdefault && p.peek() != TRBrace {
+		stmts = append(stmts, p.parseStatement())
+		if !p.accept(TSemi) {
+			break
+		}
+	}
+	return
+}
+
 func (p *parser) parseStatement() Statement {
 	pl, ok := statementParselets[p.peek()]
 	if ok {
 		return pl(p, p.next())
-	} else {
-		return ExprStmt{p.parseExpression(0)}
+	}
+
+	e := p.parseExpression(0)
+	if p.peek() != TComma {
+		return ExprStmt{e}
+	}
+
+	// Multiple assignment
+	s := AssignStmt{}
+	for {
+		l, ok := e.(LValue)
+		if !ok {
+			panic("Assign to non-lvalue")
+		}
+		s.L = append(s.L, l)
+		if !p.accept(TComma) {
+			break
+		}
+		e = p.parseExpression(PrecAssign)
+	}
+	p.require(TEquals)
+	s.R = p.parseExpressions()
+	return s
+}
+
+// parseExpressions parses a comma-separated list of expressions
+func (p *parser) parseExpressions() (exprs []Expression) {
+	for {
+		exprs = append(exprs, p.parseExpression(0))
+		if !p.accept(TComma) {
+			return
+		}
 	}
 }
 
@@ -138,13 +286,26 @@ func init() {
 		TKreturn: func(p *parser, tok Token) Statement {
 			if p.peek() == TSemi {
 				return ReturnStmt{}
-			} else {
-				e := p.parseExpression(0)
-				return ReturnStmt{e}
 			}
+			vals := p.parseExpressions()
+			if len(vals) > 1 {
+				return ReturnStmt{TupleExpr(vals)}
+			}
+			return ReturnStmt{vals[0]}
 		},
 		TKvar: func(p *parser, tok Token) Statement {
-			return p.parseVarTypes()
+			return p.parseVarsDecl()
+		},
+		TKconst: func(p *parser, tok Token) Statement {
+			return p.parseConstDecl()
+		},
+
+		TLBrace: func(p *parser, tok Token) Statement {
+			b := BlockStmt{}
+			for l := p.list(TSemi, TRBrace); l.next(); {
+				b = append(b, p.parseStatement())
+			}
+			return b
 		},
 
 		TKif: func(p *parser, tok Token) Statement {
@@ -162,6 +323,55 @@ func init() {
 			return i
 		},
 
+		TKswitch: func(p *parser, tok Token) Statement {
+			s := SwitchStmt{Value: p.parseExpression(0)}
+			p.require(TLBrace)
+			hasDefault := false
+			for !p.accept(TRBrace) {
+				sc := SwitchCase{}
+				if p.require(TKcase, TKdefault).Ty == TKcase {
+					for {
+						sc.Values = append(sc.Values, p.parseExpression(0))
+						if !p.accept(TComma) {
+							break
+						}
+					}
+				} else if hasDefault {
+					panic("Multiple default cases in switch")
+				} else {
+					hasDefault = true
+				}
+				p.require(TColon)
+				sc.Body = p.parseCaseBody()
+				s.Cases = append(s.Cases, sc)
+			}
+			return s
+		},
+
+		TKmatch: func(p *parser, tok Token) Statement {
+			s := MatchStmt{Value: p.parseExpression(0)}
+			p.require(TLBrace)
+			hasDefault := false
+			for !p.accept(TRBrace) {
+				mc := MatchCase{}
+				if p.require(TKcase, TKdefault).Ty == TKcase {
+					mc.Variant = p.require(TIdent).S
+					if p.accept(TLParen) {
+						mc.Bind = p.require(TIdent).S
+						p.require(TRParen)
+					}
+				} else if hasDefault {
+					panic("Multiple default cases in match")
+				} else {
+					hasDefault = true
+				}
+				p.require(TColon)
+				mc.Body = p.parseCaseBody()
+				s.Cases = append(s.Cases, mc)
+			}
+			return s
+		},
+
 		TKfor: func(p *parser, tok Token) Statement {
 			if p.peek() == TLBrace {
 				// No arguments
@@ -177,7 +387,7 @@ func init() {
 					if cond, ok := init.(ExprStmt); !ok {
 						panic("Expected expression, got statement")
 					} else {
-						return ForStmt{nil, cond, nil, p.parseBlock()}
+						return ForStmt{nil, cond.Expression, nil, p.parseBlock()}
 					}
 				}
 			}
@@ -199,8 +409,11 @@ func (p *parser) parseExpression(prec int) Expression {
 	if !ok {
 		p.errExpect("expression")
 	}
-	left := pl.fun(pl.prec, p, p.next())
+	return p.parseInfix(prec, pl.fun(pl.prec, p, p.next()))
+}
 
+// parseInfix parses the rest of an expression whose leftmost operand has already been parsed
+func (p *parser) parseInfix(prec int, left Expression) Expression {
 	for {
 		pl := exprParselets[p.peek()]
 		if pl.prec <= prec {
@@ -230,6 +443,18 @@ func init() {
 		TIdent: {PrecLiteral, func(prec int, p *parser, tok Token) Expression {
 			return VarExpr(tok.S)
 		}},
+		TType: {PrecLiteral, func(prec int, p *parser, tok Token) Expression {
+			if p.peek() == TLSquare {
+				ty := p.parseTypeArgs(NamedTypeExpr(tok.S))
+				p.require(TLBrace)
+				return CompositeExpr{ty, p.parseInitList()}
+			}
+			if p.accept(TLBrace) {
+				return CompositeExpr{NamedTypeExpr(tok.S), p.parseInitList()}
+			}
+			// Enum types act as namespaces containing their members
+			return VarExpr(tok.S)
+		}},
 		TString: {PrecLiteral, func(prec int, p *parser, tok Token) Expression {
 			return StringExpr(tok.S)
 		}},
@@ -237,10 +462,14 @@ func init() {
 			return RuneExpr([]rune(tok.S)[0])
 		}},
 		TFloat: {PrecLiteral, func(prec int, p *parser, tok Token) Expression {
-			return FloatExpr(tok.S)
+			e := FloatExpr(tok.S)
+			e.value() // Check the literal is in range
+			return e
 		}},
 		TInteger: {PrecLiteral, func(prec int, p *parser, tok Token) Expression {
-			return IntegerExpr(tok.S)
+			e := IntegerExpr(tok.S)
+			e.value() // Check the literal is in range
+			return e
 		}},
 
 		TLParen: {PrecGroup, func(prec int, p *parser, tok Token) Expression {
@@ -248,7 +477,16 @@ func init() {
 			p.require(TRParen)
 			return e
 		}},
+		TLBrace: {PrecGroup, func(prec int, p *parser, tok Token) Expression {
+			return p.parseInitList()
+		}},
 		TLSquare: {PrecGroup, func(prec int, p *parser, tok Token) Expression {
+			if p.peek() == TType {
+				// Array literal
+				ty := typeParselets[TLSquare](p, tok)
+				p.require(TLBrace)
+				return CompositeExpr{ty, p.parseInitList()}
+			}
 			e := p.parseExpression(0)
 			p.require(TRSquare)
 			return DerefExpr{e}
@@ -275,6 +513,44 @@ func init() {
 			p.require(TRParen)
 			return CastExpr{v, ty}
 		}},
+		TKbitcast: {PrecCall, func(prec int, p *parser, tok Token) Expression {
+			p.require(TLParen)
+			v := p.parseExpression(0)
+			p.require(TComma)
+			ty := p.parseType()
+			p.require(TRParen)
+			return BitcastExpr{v, ty}
+		}},
+		TKsizeof: {PrecCall, func(prec int, p *parser, tok Token) Expression {
+			p.require(TLParen)
+			ty := p.parseType()
+			p.require(TRParen)
+			return SizeofExpr{ty}
+		}},
+		TKalignof: {PrecCall, func(prec int, p *parser, tok Token) Expression {
+			p.require(TLParen)
+			ty := p.parseType()
+			p.require(TRParen)
+			return AlignofExpr{ty}
+		}},
+		TKoffsetof: {PrecCall, func(prec int, p *parser, tok Token) Expression {
+			p.require(TLParen)
+			ty := p.parseType()
+			p.require(TComma)
+			field := p.require(TIdent).S
+			p.require(TRParen)
+			return OffsetofExpr{ty, field}
+		}},
+		TKfn: {PrecLiteral, func(prec int, p *parser, tok Token) Expression {
+			e := FuncExpr{}
+			p.require(TLParen)
+			for l := p.list(TComma, TRParen); l.next(); {
+				e.Param = append(e.Param, p.parseVarTypes().Decls()...)
+			}
+			e.Ret = p.parseType()
+			e.Body = p.parseBlock()
+			return e
+		}},
 	}
 }
 
@@ -319,7 +595,7 @@ func init() {
 			if l, ok := left.(LValue); !ok {
 				panic("Field access of non-lvalue")
 			} else {
-				return AccessExpr{l, p.require(TIdent).S}
+				return AccessExpr{l, p.require(TIdent, TType).S}
 			}
 		}},
 
@@ -381,6 +657,27 @@ func init() {
 			}
 		}},
 
+		TLSquare: {PrecCall, func(prec int, p *parser, tok Token, left Expression) Expression {
+			var lo Expression
+			if p.peek() == TType || p.peek() == TLSquare {
+				var args []TypeExpr
+				if lo, args = p.parseIndexOrTypeArgs(); args != nil {
+					return GenericExpr{left, args}
+				}
+			} else if p.peek() != TDots {
+				lo = p.parseExpression(0)
+			}
+			if lo != nil && p.accept(TRSquare) {
+				return IndexExpr{left, lo}
+			}
+			p.require(TDots)
+			e := SliceExpr{left, lo, nil}
+			if !p.accept(TRSquare) {
+				e.Hi = p.parseExpression(0)
+				p.require(TRSquare)
+			}
+			return e
+		}},
 		TLParen: {PrecCall, func(prec int, p *parser, tok Token, left Expression) Expression {
 			call := CallExpr{Func: left}
 			for l := p.list(TComma, TRParen); l.next(); {
@@ -391,14 +688,40 @@ func init() {
 	}
 }
 
-func (p *parser) parseVarTypes() (d VarsDecl) {
-	for {
-		d.Names = append(d.Names, p.require(TIdent).S)
-		if !p.accept(TComma) {
+// parseIndexOrTypeArgs parses either an index or the type arguments of a generic function, both of which may start with a type
+// Type arguments are parsed along with the closing bracket
+func (p *parser) parseIndexOrTypeArgs() (Expression, []TypeExpr) {
+	tok := p.next()
+	var e Expression
+	switch {
+	case tok.Ty == TType && p.peek() == TDot:
+		// Enum member
+		e = VarExpr(tok.S)
+	case tok.Ty == TLSquare && p.peek() != TType && p.peek() != TKconst:
+		// Dereference
+		e = prefixExprParselets[TLSquare].fun(PrecGroup, p, tok)
+	default:
+		ty := typeParselets[tok.Ty](p, tok)
+		if p.accept(TLBrace) {
+			e = CompositeExpr{ty, p.parseInitList()}
 			break
 		}
+		args := []TypeExpr{ty}
+		for p.accept(TComma) {
+			ty := p.parseType()
+			if ty == nil {
+				p.errExpect("type")
+			}
+			args = append(args, ty)
+		}
+		p.require(TRSquare)
+		return nil, args
 	}
+	return p.parseInfix(0, e), nil
+}
 
+func (p *parser) parseVarTypes() (d VarsDecl) {
+	d.Names = p.parseNames()
 	d.Ty = p.parseType()
 	if d.Ty == nil {
 		p.errExpect("type")
@@ -406,6 +729,41 @@ func (p *parser) parseVarTypes() (d VarsDecl) {
 	return
 }
 
+// Parse a variable declaration with optional initializers
+// The type may be omitted if initializers are present
+func (p *parser) parseVarsDecl() (d VarsDecl) {
+	d.Names = p.parseNames()
+	d.Ty = p.parseType()
+	if p.accept(TEquals) {
+		for {
+			d.Init = append(d.Init, p.parseExpression(0))
+			if !p.accept(TComma) {
+				break
+			}
+		}
+	} else if d.Ty == nil {
+		p.errExpect("type or initializer")
+	}
+	return
+}
+
+func (p *parser) parseConstDecl() (d ConstDecl) {
+	d.Name = p.require(TIdent).S
+	d.Ty = p.parseType()
+	p.require(TEquals)
+	d.Value = p.parseExpression(0)
+	return
+}
+
+func (p *parser) parseNames() (names []string) {
+	for {
+		names = append(names, p.require(TIdent).S)
+		if !p.accept(TComma) {
+			return
+		}
+	}
+}
+
 func (p *parser) parseType() TypeExpr {
 	pl, ok := typeParselets[p.peek()]
 	if !ok {
@@ -426,7 +784,7 @@ func init() {
 
 	typeParselets = map[TokenType]typeParselet{
 		TType: func(p *parser, tok Token) TypeExpr {
-			return NamedTypeExpr(tok.S)
+			return p.parseTypeArgs(NamedTypeExpr(tok.S))
 		},
 
 		TIdent: func(p *parser, tok Token) TypeExpr {
@@ -436,21 +794,32 @@ func init() {
 				tok := p.require(TIdent, TType)
 				path = append(path, tok.S)
 				if tok.Ty == TType {
-					return NamespaceTypeExpr(path)
+					return p.parseTypeArgs(NamespaceTypeExpr(path))
 				}
 				p.require(TDot)
 			}
 		},
 
 		TLSquare: func(p *parser, tok Token) TypeExpr {
-			to := p.parseType()
-			if tok := p.require(TInteger, TRSquare); tok.Ty == TInteger {
-				p.require(TRSquare)
-				n, _ := strconv.Atoi(tok.S)
-				return ArrayTypeExpr{to, n}
-			} else {
-				return PointerTypeExpr{to}
+			if p.accept(TKconst) {
+				return p.parseConstPointer()
 			}
+			return p.parseSquareType(p.parseType())
+		},
+
+		TLParen: func(p *parser, tok Token) TypeExpr {
+			var t TupleTypeExpr
+			for l := p.list(TComma, TRParen); l.next(); {
+				ty := p.parseType()
+				if ty == nil {
+					p.errExpect("type")
+				}
+				t = append(t, ty)
+			}
+			if len(t) == 1 {
+				return t[0]
+			}
+			return t
 		},
 
 		TKfn: func(p *parser, tok Token) TypeExpr {
@@ -466,14 +835,52 @@ func init() {
 				t.Param = append(t.Param, ty)
 			}
 			t.Ret = p.parseType()
-			return PointerTypeExpr{t}
+			return PointerTypeExpr{To: t}
 		},
 
+		TKenum: func(p *parser, tok Token) TypeExpr {
+			e := EnumTypeExpr{Ty: p.parseType()}
+			if e.Ty == nil {
+				p.errExpect("type")
+			}
+			p.require(TLBrace)
+			for !p.accept(TRBrace) {
+				m := EnumMemberExpr{Name: p.require(TIdent).S}
+				if p.accept(TEquals) {
+					m.Value = p.parseExpression(0)
+				}
+				e.Members = append(e.Members, m)
+
+				// Members may be separated by commas or newlines
+				if !p.accept(TComma, TSemi) {
+					p.require(TRBrace)
+					break
+				}
+			}
+			return e
+		},
 		TKstruct: func(p *parser, tok Token) TypeExpr {
 			return StructTypeExpr(composite(p))
 		},
 		TKunion: func(p *parser, tok Token) TypeExpr {
 			return UnionTypeExpr(composite(p))
 		},
+		TKinterface: func(p *parser, tok Token) TypeExpr {
+			var iface InterfaceTypeExpr
+			p.require(TLBrace)
+			for l := p.list(TSemi, TRBrace); l.next(); {
+				m := MethodDecl{Name: p.require(TIdent).S}
+				p.require(TLParen)
+				for l := p.list(TComma, TRParen); l.next(); {
+					m.Param = append(m.Param, p.parseVarTypes().Decls()...)
+				}
+				m.Ret = p.parseType()
+				iface = append(iface, m)
+			}
+			return iface
+		},
+		TKvariant: func(p *parser, tok Token) TypeExpr {
+			return VariantTypeExpr(composite(p))
+		},
 	}
 }
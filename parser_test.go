@@ -101,3 +101,222 @@ func TestAssociativity(t *testing.T) {
 	testExpr(t, "a || b || c", "((a || b) || c)")
 	testExpr(t, "a = b = c", "(a = (b = c))")
 }
+
+func TestIndexParse(t *testing.T) {
+	testExpr(t, "a[i]", "a[i]")
+	testExpr(t, "m[i + 1][j]", "m[(i + 1)][j]")
+	testExpr(t, "-p.a[2]", "-(p.a[2])")
+	testStmt(t, "a[0] = [b]", "(a[0] = [b])")
+}
+
+func TestSliceParse(t *testing.T) {
+	testExpr(t, "a[1..n]", "a[1..n]")
+	testExpr(t, "a[..]", "a[..]")
+	testExpr(t, "a[i + 1..]", "a[(i + 1)..]")
+	testExpr(t, "len(s[..2])", "len(s[..2])")
+	testProg(t, "var s [I32 ..]", "var s [I32 ..]")
+}
+
+func TestCompositeParse(t *testing.T) {
+	testExpr(t, "Point{x: 1, y: 2}", "Point{x: 1, y: 2}")
+	testExpr(t, "[I32 3]{1, 2, 3}", "[I32 3]{1, 2, 3}")
+	testExpr(t, "Line{a: {1, 2}, b: Point{}}", "Line{a: {1, 2}, b: Point{}}")
+	testExpr(t, "[p]", "[p]")
+}
+
+func TestCastParse(t *testing.T) {
+	testExpr(t, "cast(p, [Header])", "cast(p, [Header])")
+	testExpr(t, "bitcast(x + 1, U64)", "bitcast((x + 1), U64)")
+}
+
+func TestSizeofParse(t *testing.T) {
+	testExpr(t, "sizeof([I32 4]) * 2", "(sizeof([I32 4]) * 2)")
+	testExpr(t, "alignof(Point)", "alignof(Point)")
+	testExpr(t, "offsetof(Point, y)", "offsetof(Point, y)")
+}
+
+func TestMultipleReturnParse(t *testing.T) {
+	testProg(t, `
+		fn divmod(a, b U64) (U64, U64) {
+			return a / b, a % b
+		}
+	`, `
+		fn divmod(a U64, b U64) (U64, U64) {
+			return (a / b), (a % b)
+		}
+	`)
+	testStmt(t, "q, _ = divmod(x, y)", "q, _ = divmod(x, y)")
+	testStmt(t, "a, b.c = b.c, a", "a, b.c = b.c, a")
+}
+
+func TestGlobalInitializer(t *testing.T) {
+	testProg(t, `
+		var a I32 = 1
+		var b, c [I32 2] = {1, 2}, {3, 4 + 5}
+	`, `
+		var a I32 = 1
+		var b, c [I32 2] = {1, 2}, {3, (4 + 5)}
+	`)
+}
+
+func TestLocalInitializer(t *testing.T) {
+	testStmt(t, "var a, b I32 = 1, 2", "var a, b I32 = 1, 2")
+	testStmt(t, "var a = b + 1", "var a = (b + 1)")
+}
+
+func TestBlock(t *testing.T) {
+	testStmt(t, "{ var a I32; a = 1 }", "{\n\tvar a I32\n\t(a = 1)\n}")
+}
+
+func TestConstDecl(t *testing.T) {
+	testProg(t, `
+		const n = 1 + 2
+		const m U8 = n
+		var a [I32 n * 2]
+	`, `
+		const n = (1 + 2)
+		const m U8 = n
+		var a [I32 (n * 2)]
+	`)
+}
+
+func TestEnumType(t *testing.T) {
+	testProg(t, `
+		type Color enum U8 {
+			red, green
+			blue = 1 << 3
+		}
+	`, `
+		type Color enum U8 {red, green, blue = (1 << 3)}
+	`)
+	testExpr(t, "Color.red", "Color.red")
+}
+
+func TestVariantType(t *testing.T) {
+	testProg(t, `
+		type Shape variant {
+			circle F64
+			rect struct { w, h F64 }
+		}
+	`, `
+		type Shape variant {
+			circle F64
+			rect struct {
+				w F64
+				h F64
+			}
+		}
+	`)
+}
+
+func TestMatchParse(t *testing.T) {
+	testStmt(t, `match s {
+	case circle(r):
+		f(r)
+	case rect:
+	default:
+		g()
+	}`, `match s {
+	case circle(r):
+		f(r)
+	case rect:
+	default:
+		g()
+	}`)
+}
+
+func TestSwitchParse(t *testing.T) {
+	testStmt(t, `switch x {
+	case 1, 2:
+		f()
+		g()
+	case 3:
+	default:
+		h()
+	}`, `switch x {
+	case 1, 2:
+		f()
+		g()
+	case 3:
+	default:
+		h()
+	}`)
+}
+
+func TestMethodParse(t *testing.T) {
+	testProg(t, `
+		fn (v [Vec]) push(x I32) {
+			v.n = v.n + x
+		}
+	`, `
+		fn (v [Vec]) push(x I32) {
+			(v.n = (v.n + x))
+		}
+	`)
+	testExpr(t, "v.push(1)", "v.push(1)")
+}
+
+func TestInterfaceParse(t *testing.T) {
+	testProg(t, `
+		type Writer interface {
+			write(buf [I8], n U64) I64
+			close()
+		}
+	`, `
+		type Writer interface {
+			write(buf [I8], n U64) I64
+			close()
+		}
+	`)
+}
+
+func TestGenericParse(t *testing.T) {
+	testProg(t, `
+		type Pair[A, B] struct { a A; b B }
+		type List[T] [Node[T]]
+		type P [T]
+		type Q [I32 4]
+		fn max[T](a, b T) T {
+			return a
+		}
+	`, `
+		type Pair[A, B] struct {
+			a A
+			b B
+		}
+		type List[T] [Node[T]]
+		type P [T]
+		type Q [I32 4]
+		fn max[T](a T, b T) T {
+			return a
+		}
+	`)
+	testExpr(t, "Pair[I32, geo.Point]{1, p}", "Pair[I32, geo.Point]{1, p}")
+	testExpr(t, "max[I32](a, b)", "max[I32](a, b)")
+	testExpr(t, "f[Pair[I8, U8], [const I8], [I8 4]]()", "f[Pair[I8, U8], [const I8], [I8 4]]()")
+	testExpr(t, "a[Color.red + 1]", "a[(Color.red + 1)]")
+	testExpr(t, "a[[p] * 2]", "a[([p] * 2)]")
+	testExpr(t, "a[[I32 2]{0, 1}[i]..]", "a[[I32 2]{0, 1}[i]..]")
+}
+
+func TestFuncLiteralParse(t *testing.T) {
+	testExpr(t, "sort(a, fn(x, y []) I32 { return 0 })", `sort(a, fn(x [], y []) I32 {
+		return 0
+	})`)
+}
+
+func TestConstPointerParse(t *testing.T) {
+	testProg(t, `
+		type S [const I8]
+		type T [const I8 ..]
+		fn f(p [const], q [const [I32 4]], s [const I32 ..]) [const I8] {
+			return p
+		}
+	`, `
+		type S [const I8]
+		type T [const I8 ..]
+		fn f(p [const], q [const [I32 4]], s [const I32 ..]) [const I8] {
+			return p
+		}
+	`)
+}
@@ -0,0 +1,116 @@
+package main
+
+import "testing"
+
+func TestRunCompositeABI(t *testing.T) {
+	testRun(t, `
+		type DivT struct { quot, rem I32 }
+		type Pair struct { a, b I32 }
+		type Mixed struct { c I8; d F64; e I64 }
+		type Num union { i I64; f F64 }
+		type Wide union { a [U8 9]; b U64 }
+		type Holder struct { c I8; u Wide; d I8 }
+
+		fn div(num, den I32) DivT
+		variadic fn printf(fmt [const I8]) I32
+
+		fn cPair(a, b I32) Pair
+		fn cSum(p Pair) I32
+		fn cMixed(m Mixed) Mixed
+		fn cNum(n Num) Num
+		fn cSwapped() I32
+		fn cHolder(h Holder) Holder
+		fn cHolderSize() U64
+
+		pub fn swap(p Pair) Pair {
+			var q Pair
+			q.a = p.b
+			q.b = p.a
+			return q
+		}
+
+		pub fn main() I32 {
+			var d DivT
+			d = div(17, 5)
+			_ = printf("%d %d\n", d.quot, d.rem)
+
+			var p Pair
+			p = swap(cPair(1, 2))
+			_ = printf("%d %d %d\n", p.a, p.b, cSum(p))
+			_ = printf("%d\n", cSwapped())
+
+			var m Mixed
+			m.c = 1
+			m.d = 1.5
+			m.e = 10
+			m = cMixed(m)
+			_ = printf("%d %g %ld\n", m.c, m.d, m.e)
+
+			var n Num
+			n.i = 41
+			n = cNum(n)
+			_ = printf("%ld\n", n.i)
+
+			var h Holder
+			h.c = 1
+			h.u.b = 0x0807060504030201
+			h.u.a[8] = 9
+			h.d = 2
+			h = cHolder(h)
+			_ = printf("%d %lx %d %d %d\n", h.c, h.u.b, h.u.a[8], h.d, cast(cHolderSize() == sizeof(Holder), I32))
+			return 0
+		}
+	`, `
+		typedef struct { int a, b; } Pair;
+		typedef struct { char c; double d; long e; } Mixed;
+		typedef union { long i; double f; } Num;
+
+		Pair swap(Pair p);
+
+		Pair cPair(int a, int b) { Pair p = {a, b}; return p; }
+		int cSum(Pair p) { return p.a + p.b; }
+		int cSwapped(void) { Pair p = swap(cPair(3, 4)); return p.a * 10 + p.b; }
+		Mixed cMixed(Mixed m) { m.c++; m.d *= 2; m.e++; return m; }
+		Num cNum(Num n) { n.i++; return n; }
+
+		typedef union { unsigned char a[9]; unsigned long b; } Wide;
+		typedef struct { char c; Wide u; char d; } Holder;
+		Holder cHolder(Holder h) { h.c++; h.u.b++; h.u.a[8]++; h.d++; return h; }
+		unsigned long cHolderSize(void) { return sizeof(Holder); }
+	`, "3 2\n2 1 3\n43\n2 3 11\n42\n2 807060504030202 10 3 1\n")
+}
+
+func TestRunIntConversion(t *testing.T) {
+	testRun(t, `
+		variadic fn printf(fmt [const I8]) I32
+		fn cNeg() I8
+
+		pub fn main() I32 {
+			// Narrowing truncates, widening extends according to the source type
+			var x I64 = 0x1ff
+			var u = cast(x, U8)
+			var s = cast(u, I8)
+			var w = cast(s, U16)
+			_ = printf("%d %d %d %lu %ld\n", cast(u, I32), cast(s, I32), cast(w, I32), cast(s, U64), cast(u, I64))
+
+			// Arithmetic wraps in narrow types, so comparisons see the wrapped value
+			var a, b U8 = 200, 100
+			var n I8 = -128
+			n = -n
+			_ = printf("%d %d %d\n", cast(a + b, I32), cast(n, I32), cast(a + b < a, I32))
+			_ = printf("%d %d\n", cast(cast(x, U8) == 255, I32), cast(cNeg(), I32))
+
+			// Pointer arithmetic extends signed offsets
+			var arr [I32 4] = {1, 2, 3, 4}
+			var p [I32] = &arr[3]
+			var i I32 = -2
+			_ = printf("%d %d\n", [i + p], [p + i])
+
+			// Conversion to Bool tests whether the value is nonzero
+			_ = printf("%d %d\n", cast(cast(x + 1, Bool), I32), cast(cast(x - 511, Bool), I32))
+			return 0
+		}
+	`, `
+		signed char cNeg(void) { return -5; }
+	`, "255 -1 65535 18446744073709551615 255\n44 -128 1\n1 -5\n2 2\n1 0\n")
+}
@@ -49,44 +49,58 @@ func _() {
 	_ = x[TCne-38]
 	_ = x[TCle-39]
 	_ = x[TCge-40]
-	_ = x[TEquals-41]
-	_ = x[TPlus-42]
-	_ = x[TMinus-43]
-	_ = x[TAster-44]
-	_ = x[TSlash-45]
-	_ = x[TPerc-46]
-	_ = x[TExcl-47]
-	_ = x[TPipe-48]
-	_ = x[TCaret-49]
-	_ = x[TAmp-50]
-	_ = x[TLess-51]
-	_ = x[TGreater-52]
-	_ = x[TDot-53]
-	_ = x[TInvalid-54]
-	_ = x[LexTokenMax-55]
-	_ = x[TKeywordStart-56]
-	_ = x[TKbreak-57]
-	_ = x[TKcast-58]
-	_ = x[TKcontinue-59]
-	_ = x[TKelse-60]
-	_ = x[TKextern-61]
-	_ = x[TKfn-62]
-	_ = x[TKfor-63]
-	_ = x[TKif-64]
-	_ = x[TKns-65]
-	_ = x[TKpub-66]
-	_ = x[TKreturn-67]
-	_ = x[TKstruct-68]
-	_ = x[TKtype-69]
-	_ = x[TKunion-70]
-	_ = x[TKvar-71]
-	_ = x[TKvariadic-72]
-	_ = x[TKeywordEnd-73]
+	_ = x[TDots-41]
+	_ = x[TEquals-42]
+	_ = x[TPlus-43]
+	_ = x[TMinus-44]
+	_ = x[TAster-45]
+	_ = x[TSlash-46]
+	_ = x[TPerc-47]
+	_ = x[TExcl-48]
+	_ = x[TPipe-49]
+	_ = x[TCaret-50]
+	_ = x[TAmp-51]
+	_ = x[TLess-52]
+	_ = x[TGreater-53]
+	_ = x[TDot-54]
+	_ = x[TColon-55]
+	_ = x[TInvalid-56]
+	_ = x[LexTokenMax-57]
+	_ = x[TKeywordStart-58]
+	_ = x[TKalignof-59]
+	_ = x[TKbitcast-60]
+	_ = x[TKbreak-61]
+	_ = x[TKcase-62]
+	_ = x[TKcast-63]
+	_ = x[TKconst-64]
+	_ = x[TKcontinue-65]
+	_ = x[TKdefault-66]
+	_ = x[TKelse-67]
+	_ = x[TKenum-68]
+	_ = x[TKextern-69]
+	_ = x[TKfn-70]
+	_ = x[TKfor-71]
+	_ = x[TKif-72]
+	_ = x[TKinterface-73]
+	_ = x[TKmatch-74]
+	_ = x[TKns-75]
+	_ = x[TKoffsetof-76]
+	_ = x[TKpub-77]
+	_ = x[TKreturn-78]
+	_ = x[TKsizeof-79]
+	_ = x[TKstruct-80]
+	_ = x[TKswitch-81]
+	_ = x[TKtype-82]
+	_ = x[TKunion-83]
+	_ = x[TKvar-84]
+	_ = x[TKvariant-85]
+	_ = x[TKvariadic-86]
+	_ = x[TKeywordEnd-87]
 }
 
-const _TokenType_name = "end of filecommentwhitespacenewline'\\'';'',''('')''['']''{''}'identifiertype namestring literalcharacter literalfloat literalinteger literal'+=''-=''*=''/=''%=''|=''^=''&=''<<=''>>=''&&=''||=''++''--''<<''>>''&&''||''==''!=''<=''>=''=''+''-''*''/''%''!''|''^''&''<''>''.'invalid tokenLexTokenMaxTKeywordStart'break''cast''continue''else''extern''fn''for''if''ns''pub''return''struct''type''union''var''variadic'TKeywordEnd"
+const _TokenType_name = "end of filecommentwhitespacenewline'\\'';'',''('')''['']''{''}'identifiertype namestring literalcharacter literalfloat literalinteger literal'+=''-=''*=''/=''%=''|=''^=''&=''<<=''>>=''&&=''||=''++''--''<<''>>''&&''||''==''!=''<=''>=''..''=''+''-''*''/''%''!''|''^''&''<''>''.'':'invalid tokenLexTokenMaxTKeywordStart'alignof''bitcast''break''case''cast''const''continue''default''else''enum''extern''fn''for''if''interface''match''ns''offsetof''pub''return''sizeof''struct''switch''type''union''var''variant''variadic'TKeywordEnd"
 
-var _TokenType_index = [...]uint16{0, 11, 18, 28, 35, 38, 41, 44, 47, 50, 53, 56, 59, 62, 72, 81, 95, 112, 125, 140, 144, 148, 152, 156, 160, 164, 168, 172, 177, 182, 187, 192, 196, 200, 204, 208, 212, 216, 220, 224, 228, 232, 235, 238, 241, 244, 247, 250, 253, 256, 259, 262, 265, 268, 271, 284, 295, 308, 315, 321, 331, 337, 345, 349, 354, 358, 362, 367, 375, 383, 389, 396, 401, 411, 422}
+var _TokenType_index = [...]uint16{0, 11, 18, 28, 35, 38, 41, 44, 47, 50, 53, 56, 59, 62, 72, 81, 95, 112, 125, 140, 144, 148, 152, 156, 160, 164, 168, 172, 177, 182, 187, 192, 196, 200, 204, 208, 212, 216, 220, 224, 228, 232, 236, 239, 242, 245, 248, 251, 254, 257, 260, 263, 266, 269, 272, 275, 278, 291, 302, 315, 324, 333, 340, 346, 352, 359, 369, 378, 384, 390, 398, 402, 407, 411, 422, 429, 433, 443, 448, 456, 464, 472, 480, 486, 493, 498, 507, 517, 528}
 
 func (i TokenType) String() string {
 	if i < 0 || i >= TokenType(len(_TokenType_index)-1) {
@@ -20,9 +20,15 @@ func typeCheck(errCtx string, a, b Type) {
 }
 
 func (e AccessExpr) TypeOf(c *Compiler) Type {
+	if ns, ok := e.L.TypeOf(c).(Namespace); ok {
+		return c.nsMember(ns, e.R).Ty
+	}
+	return decayLValue(c, e)
+}
+func (e AccessExpr) storageType(c *Compiler) Type {
 	lty := e.L.TypeOf(c)
 	if ns, ok := lty.(Namespace); ok {
-		return ns.Vars[e.R]
+		return c.nsMember(ns, e.R).Ty
 	}
 
 	for {
@@ -38,29 +44,106 @@ func (e AccessExpr) TypeOf(c *Compiler) Type {
 		if f == nil {
 			panic("No such field: " + e.R)
 		}
-		if a, ok := f.Concrete().(ArrayType); ok {
-			return a.ptr()
-		}
 		return f
 	}
 
 	panic("Access of non-composite type " + lty.Format(0))
 }
 
+// variant returns the type of the accessed value if it is a variant
+func (e AccessExpr) variant(c *Compiler) (VariantType, bool) {
+	lty := e.L.TypeOf(c)
+	if _, ok := lty.(Namespace); ok {
+		return VariantType{}, false
+	}
+	for {
+		if p, ok := lty.Concrete().(PointerType); ok {
+			lty = p.To
+		} else {
+			break
+		}
+	}
+	v, ok := lty.Concrete().(VariantType)
+	return v, ok
+}
+
 func (e AssignExpr) typeOf(c *Compiler) Type {
 	if name, ok := e.L.(VarExpr); ok && name == "_" {
-		e.R.TypeOf(c)
+		if n := valueCount(e.R.TypeOf(c)); n > 1 {
+			panic(fmt.Sprintf("Assignment mismatch: 1 variables but %d values", n))
+		}
 		return nil
 	}
+	if _, ok := constValue(c, e.L); ok {
+		panic("Cannot assign to constant " + e.L.Format(0))
+	}
+	if readOnly(c, e.L) {
+		panic("Cannot assign through const pointer: " + e.L.Format(0))
+	}
 
-	ltyp := e.L.TypeOf(c)
+	ltyp := e.L.storageType(c)
 	if !ltyp.IsConcrete() {
 		panic("Lvalue of non-concrete type")
 	}
-	rtyp := e.R.TypeOf(c)
-	typeCheck("assignment", rtyp, ltyp)
+	typeCheck("assignment", valueType(c, e.R, ltyp), ltyp)
+	checkRange(c, e.R, ltyp)
 	return ltyp
 }
+
+// readOnly returns true if e refers to memory that may not be modified, because it is reached through a const pointer or slice
+func readOnly(c *Compiler, e Expression) bool {
+	switch e := e.(type) {
+	case DerefExpr:
+		p, ok := e.V.TypeOf(c).Concrete().(PointerType)
+		return ok && p.Const
+	case IndexExpr:
+		switch ty := undecayedType(c, e.V).Concrete().(type) {
+		case PointerType:
+			return ty.Const
+		case SliceType:
+			return ty.Const
+		case ArrayType:
+			return readOnly(c, e.V)
+		}
+	case AccessExpr:
+		lty := e.L.TypeOf(c)
+		if _, ok := lty.(Namespace); ok {
+			return false
+		}
+		// Field access dereferences pointers automatically, so the last pointer decides
+		if p, ok := lty.Concrete().(PointerType); ok {
+			for {
+				if next, ok := p.To.Concrete().(PointerType); ok {
+					p = next
+				} else {
+					return p.Const
+				}
+			}
+		}
+		return readOnly(c, e.L)
+	}
+	return false
+}
+
+// decayLValue returns the decayed type of lv, which points to read-only memory if lv is read-only
+func decayLValue(c *Compiler, lv LValue) Type {
+	ty := lv.storageType(c)
+	if a, ok := ty.Concrete().(ArrayType); ok && readOnly(c, lv) {
+		return PointerType{To: a.Ty, Const: true}
+	}
+	return decay(ty)
+}
+
+// valueType returns the type of e when it is stored in a location of type ty
+func valueType(c *Compiler, e Expression, ty Type) Type {
+	if _, ok := ty.Concrete().(ArrayType); ok {
+		// Arrays are assigned by value, so the value must not decay
+		if lv, ok := e.(LValue); ok {
+			return lv.storageType(c)
+		}
+	}
+	return e.TypeOf(c)
+}
 func (e MutateExpr) typeOf(c *Compiler) Type {
 	return AssignExpr{e.L, BinaryExpr{e.Op, e.L, e.R}}.typeOf(c)
 }
@@ -79,7 +162,18 @@ func (e CallExpr) typeOf(c *Compiler) (t FuncType, ptr bool) {
 	panic("Call of non-function type")
 }
 func (e CallExpr) TypeOf(c *Compiler) Type {
-	t, _ := e.typeOf(c)
+	var t FuncType
+	if b, ok := e.builtin(c); ok {
+		return b.TypeOf(c)
+	} else if _, _, dt, ok := e.dynamic(c); ok {
+		t = dt
+	} else if m, ok := e.method(c); ok {
+		return m.TypeOf(c)
+	} else if g, ok := e.generic(c); ok {
+		return g.TypeOf(c)
+	} else {
+		t, _ = e.typeOf(c)
+	}
 	na, np := len(e.Args), len(t.Param)
 	if na < np || (na > np && !t.Var) {
 		panic(fmt.Sprintf("Incorrect number of arguments in call to %s: expected %d, got %d", e.Func.Format(0), len(t.Param), len(e.Args)))
@@ -87,23 +181,320 @@ func (e CallExpr) TypeOf(c *Compiler) Type {
 	errCtx := "call to " + e.Func.Format(0)
 	for i, par := range t.Param {
 		typeCheck(errCtx, e.Args[i].TypeOf(c), par)
+		checkRange(c, e.Args[i], par)
 	}
 	return t.Ret
 }
 
+// builtin resolves a call of a builtin function, unless its name refers to a variable
+func (e CallExpr) builtin(c *Compiler) (Expression, bool) {
+	name, ok := e.Func.(VarExpr)
+	if !ok || name != "len" {
+		return nil, false
+	}
+	if _, ok := c.lookupVariable(string(name)); ok {
+		return nil, false
+	}
+	if len(e.Args) != 1 {
+		panic(fmt.Sprintf("Incorrect number of arguments in call to len: expected 1, got %d", len(e.Args)))
+	}
+	return LenExpr{e.Args[0]}, true
+}
+
+// dynamic resolves a call of the form v.m(args), where v is an interface, to a call through its vtable
+// It returns the interface value, the index of the method in the vtable and the type of the method
+func (e CallExpr) dynamic(c *Compiler) (v Expression, i int, t FuncType, ok bool) {
+	acc, ok := e.Func.(AccessExpr)
+	if !ok {
+		return
+	}
+	ty := acc.L.TypeOf(c)
+	if _, ok = ty.(Namespace); ok {
+		return nil, 0, t, false
+	}
+
+	v = acc.L
+	for {
+		if p, ok := ty.Concrete().(PointerType); ok && p.To != nil {
+			ty = p.To
+			v = DerefExpr{v}
+		} else {
+			break
+		}
+	}
+	iface, ok := ty.Concrete().(InterfaceType)
+	if !ok {
+		return
+	}
+	i = iface.Method(acc.R)
+	if i < 0 {
+		panic(ty.Format(0) + " has no method " + acc.R)
+	}
+	return v, i, iface.compositeType[i].Ty.(FuncType), true
+}
+
+// method resolves a call of the form v.m(args) to a call of a method of v's type,
+// with v passed as the receiver
+func (e CallExpr) method(c *Compiler) (CallExpr, bool) {
+	acc, ok := e.Func.(AccessExpr)
+	if !ok {
+		return e, false
+	}
+	ty := acc.L.TypeOf(c)
+	if _, ok := ty.(Namespace); ok {
+		return e, false
+	}
+
+	// Dereference pointers until we reach a named type, like field access does
+	depth := 0
+	for {
+		if _, ok := ty.(NamedType); ok {
+			break
+		}
+		if p, ok := ty.Concrete().(PointerType); ok && p.To != nil {
+			ty = p.To
+			depth++
+		} else {
+			break
+		}
+	}
+	named, ok := ty.(NamedType)
+	if !ok {
+		return e, false
+	}
+	if comp, ok := named.Concrete().(CompositeType); ok && comp.Field(acc.R) != nil {
+		// Fields take precedence over methods
+		return e, false
+	}
+	m := MethodExpr{named, acc.R}
+	fn, ok := m.TypeOf(c).(FuncType)
+	if !ok || len(fn.Param) == 0 {
+		return e, false
+	}
+
+	// Take the address of or dereference the receiver to match the method
+	want := 1
+	if fn.Param[0].Equals(named) {
+		want = 0
+	}
+	recv := Expression(acc.L)
+	if depth < want {
+		recv = RefExpr{acc.L}
+	}
+	for ; depth > want; depth-- {
+		recv = DerefExpr{recv}
+	}
+	return CallExpr{m, append([]Expression{recv}, e.Args...)}, true
+}
+
+// generic resolves a call of a generic function to a call of its instance for the types of the arguments
+func (e CallExpr) generic(c *Compiler) (CallExpr, bool) {
+	f, ok := e.Func.TypeOf(c).(GenericFunc)
+	if !ok {
+		return e, false
+	}
+	if len(e.Args) != len(f.Param) {
+		panic(fmt.Sprintf("Incorrect number of arguments in call to %s: expected %d, got %d", e.Func.Format(0), len(f.Param), len(e.Args)))
+	}
+	types := make([]Type, len(e.Args))
+	for i, arg := range e.Args {
+		types[i] = arg.TypeOf(c)
+	}
+
+	var args []ConcreteType
+	c.withTypes(f.ns, nil, nil, func() {
+		args = f.infer(c, types)
+	})
+	return CallExpr{f.Instantiate(c, args), e.Args}, true
+}
+
+// infer finds the type arguments of a generic function from the types of the arguments to a call
+func (f GenericFunc) infer(c *Compiler, types []Type) []ConcreteType {
+	bound := map[string]ConcreteType{}
+	for _, name := range f.TypeParam {
+		bound[name] = nil
+	}
+
+	// Match a parameter type against an argument type, binding any unbound type parameters
+	// Untyped literals only bind type parameters once everything else has been matched
+	var lits bool
+	var unify func(expr TypeExpr, ty Type)
+	unify = func(expr TypeExpr, ty Type) {
+		if ty == nil {
+			return
+		}
+		if !ty.IsConcrete() {
+			switch ty.(type) {
+			case IntLitType, FloatLitType:
+				if !lits {
+					return
+				}
+				ty = ty.Concrete()
+			default:
+				return
+			}
+		}
+
+		switch expr := expr.(type) {
+		case NamedTypeExpr:
+			if v, ok := bound[string(expr)]; ok && v == nil {
+				bound[string(expr)] = ty.(ConcreteType)
+			}
+		case GenericTypeExpr:
+			named, ok := ty.(NamedType)
+			if !ok {
+				return
+			}
+			inst, ok := c.inst[named.Name].(typeInstance)
+			if !ok || inst.gen != expr.generic(c) {
+				return
+			}
+			for i, arg := range expr.Args {
+				if i < len(inst.args) {
+					unify(arg, inst.args[i])
+				}
+			}
+		case PointerTypeExpr:
+			if p, ok := ty.Concrete().(PointerType); ok && expr.To != nil {
+				unify(expr.To, p.To)
+			}
+		case ArrayTypeExpr:
+			if a, ok := ty.Concrete().(ArrayType); ok {
+				unify(expr.Ty, a.Ty)
+			}
+		case SliceTypeExpr:
+			if s, ok := ty.Concrete().(SliceType); ok {
+				unify(expr.Ty, s.Ty)
+			}
+		case TupleTypeExpr:
+			if t, ok := ty.Concrete().(TupleType); ok && len(t) == len(expr) {
+				for i := range expr {
+					unify(expr[i], t[i])
+				}
+			}
+		case FuncTypeExpr:
+			if fn, ok := ty.Concrete().(FuncType); ok && len(fn.Param) == len(expr.Param) {
+				for i := range expr.Param {
+					unify(expr.Param[i], fn.Param[i])
+				}
+				if expr.Ret != nil {
+					unify(expr.Ret, fn.Ret)
+				}
+			}
+		}
+	}
+	for _, lits = range []bool{false, true} {
+		for i, param := range f.Param {
+			unify(param.Ty, types[i])
+		}
+	}
+
+	args := make([]ConcreteType, len(f.TypeParam))
+	for i, name := range f.TypeParam {
+		if args[i] = bound[name]; args[i] == nil {
+			panic("Cannot infer type parameter " + name + " in call to " + f.Name + "; give the type arguments explicitly")
+		}
+	}
+	return args
+}
+
+func (e InstanceExpr) TypeOf(c *Compiler) Type {
+	return e.Ty
+}
+func (e InstanceExpr) storageType(c *Compiler) Type {
+	return e.Ty
+}
+
+func (e GenericExpr) TypeOf(c *Compiler) Type {
+	return e.instance(c).Ty
+}
+func (e GenericExpr) storageType(c *Compiler) Type {
+	return e.instance(c).Ty
+}
+
+// instance returns the instance of the generic function for the given type arguments
+func (e GenericExpr) instance(c *Compiler) InstanceExpr {
+	f, ok := e.Func.TypeOf(c).(GenericFunc)
+	if !ok {
+		panic("Type arguments given to non-generic " + e.Func.Format(0))
+	}
+	args := make([]ConcreteType, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.Get(c)
+	}
+	return f.Instantiate(c, args)
+}
+
+func (e MethodExpr) TypeOf(c *Compiler) Type {
+	return e.storageType(c)
+}
+func (e MethodExpr) storageType(c *Compiler) Type {
+	v, _ := c.method(e.Ty, e.Name)
+	return v.Ty
+}
+
 func (e CastExpr) TypeOf(c *Compiler) Type {
-	if _, ok := e.V.TypeOf(c).Concrete().(NumericType); !ok {
-		panic("Cast of non-numeric type " + e.V.TypeOf(c).Format(0))
+	from := castSource(c, e.V)
+	fn, ok := from.Concrete().(NumericType)
+	if !ok {
+		panic("Cast of non-numeric type " + from.Format(0))
 	}
 	ty := e.Ty.Get(c)
-	if _, ok := ty.Concrete().(NumericType); !ok {
+	tn, ok := ty.Concrete().(NumericType)
+	if !ok {
 		panic("Cast to non-numeric type " + ty.Format(0))
 	}
+
+	// Pointers may only be converted to other pointers, or integers of the same size
+	_, fptr := fn.(PointerType)
+	_, tptr := tn.(PointerType)
+	if fptr && !tptr && !isPtrInt(tn) || tptr && !fptr && !isPtrInt(fn) {
+		panic("Cannot cast " + from.Format(0) + " to " + ty.Format(0) + ": pointers can only be cast to pointers, I64 or U64")
+	}
 	return ty
 }
 
+func (e BitcastExpr) TypeOf(c *Compiler) Type {
+	from := castSource(c, e.V)
+	fn, ok := from.Concrete().(NumericType)
+	if !ok {
+		panic("Bitcast of non-numeric type " + from.Format(0))
+	}
+	ty := e.Ty.Get(c)
+	tn, ok := ty.Concrete().(NumericType)
+	if !ok {
+		panic("Bitcast to non-numeric type " + ty.Format(0))
+	}
+	if fn.Metrics().Size != tn.Metrics().Size {
+		panic("Cannot bitcast " + from.Format(0) + " to " + ty.Format(0) + ": sizes differ")
+	}
+	return ty
+}
+
+// castSource returns the type of the operand of a cast, treating functions as function pointers
+func castSource(c *Compiler, e Expression) Type {
+	ty := e.TypeOf(c)
+	if f, ok := ty.(FuncType); ok {
+		return PointerType{To: f}
+	}
+	return ty
+}
+
+// isPtrInt returns true if ty is an integer type that can hold a pointer
+func isPtrInt(ty NumericType) bool {
+	_, isPtr := ty.(PointerType)
+	return !isPtr && !isFloat(ty) && ty.Metrics().Size == 8
+}
+
 func (e VarExpr) TypeOf(c *Compiler) Type {
-	ty := c.Variable(string(e)).Ty
+	return decay(e.storageType(c))
+}
+func (e VarExpr) storageType(c *Compiler) Type {
+	return c.Variable(string(e)).Ty
+}
+
+// decay converts array types to pointers to their first element
+func decay(ty Type) Type {
 	if ty.IsConcrete() {
 		if a, ok := ty.Concrete().(ArrayType); ok {
 			return a.ptr()
@@ -113,7 +504,13 @@ func (e VarExpr) TypeOf(c *Compiler) Type {
 }
 
 func (e RefExpr) TypeOf(c *Compiler) Type {
-	return PointerType{e.V.TypeOf(c).Concrete()}
+	ty := e.V.TypeOf(c)
+	ro := readOnly(c, e.V)
+	if ty, ok := ty.(ConcreteType); ok {
+		// Keep the name of named types
+		return PointerType{ty, ro}
+	}
+	return PointerType{ty.Concrete(), ro}
 }
 
 func (e DerefExpr) TypeOf(c *Compiler) Type {
@@ -126,24 +523,183 @@ func (e DerefExpr) TypeOf(c *Compiler) Type {
 		panic("Dereference of non-pointer type")
 	}
 }
+func (e DerefExpr) storageType(c *Compiler) Type {
+	return e.TypeOf(c)
+}
+
+func (e IndexExpr) TypeOf(c *Compiler) Type {
+	return decayLValue(c, e)
+}
+func (e IndexExpr) storageType(c *Compiler) Type {
+	checkIndex(c, e.I)
+	switch ty := undecayedType(c, e.V).Concrete().(type) {
+	case ArrayType:
+		constIndex(c, ty, e.I)
+		return ty.Ty
+	case SliceType:
+		return ty.Ty
+	case PointerType:
+		if ty.To == nil {
+			panic("Generic pointer may not be indexed")
+		}
+		return ty.To
+	}
+	panic("Index of non-array type " + e.V.TypeOf(c).Format(0))
+}
+
+func (e SliceExpr) TypeOf(c *Compiler) Type {
+	if e.Lo != nil {
+		checkIndex(c, e.Lo)
+	}
+	if e.Hi != nil {
+		checkIndex(c, e.Hi)
+	}
+	switch ty := undecayedType(c, e.V).Concrete().(type) {
+	case ArrayType:
+		return SliceType{ty.Ty, readOnly(c, e.V)}
+	case SliceType:
+		return ty
+	case PointerType:
+		if ty.To == nil {
+			panic("Generic pointer may not be sliced")
+		}
+		if e.Hi == nil {
+			panic("Slice of pointer must have an upper bound")
+		}
+		return SliceType{ty.To, ty.Const}
+	}
+	panic("Slice of non-array type " + e.V.TypeOf(c).Format(0))
+}
+
+func (e LenExpr) TypeOf(c *Compiler) Type {
+	switch undecayedType(c, e.V).Concrete().(type) {
+	case ArrayType, SliceType:
+		return TypeU64
+	}
+	panic("Length of non-array type " + e.V.TypeOf(c).Format(0))
+}
+
+func (_ SizeofExpr) TypeOf(c *Compiler) Type {
+	return TypeU64
+}
+func (_ AlignofExpr) TypeOf(c *Compiler) Type {
+	return TypeU64
+}
+func (_ OffsetofExpr) TypeOf(c *Compiler) Type {
+	return TypeU64
+}
+
+// valueCount returns the number of values held by a value of type ty
+func valueCount(ty Type) int {
+	switch ty := ty.(type) {
+	case nil:
+		return 0
+	case TupleType:
+		return len(ty)
+	}
+	return 1
+}
+
+// pointee returns the named type pointed to by a pointer being converted to an interface
+func pointee(ty Type) NamedType {
+	return ty.Concrete().(PointerType).To.(NamedType)
+}
+
+// checkIndex panics if e cannot be used as an index
+func checkIndex(c *Compiler, e Expression) {
+	ty := e.TypeOf(c)
+	_, isNum := ty.Concrete().(NumericType)
+	_, isPtr := ty.Concrete().(PointerType)
+	if !isNum || isPtr || isFloat(ty) {
+		panic("Index must be an integer, not " + ty.Format(0))
+	}
+}
+
+// constIndex returns the value of an index into an array if it is constant, panicking if it is out of range
+func constIndex(c *Compiler, a ArrayType, e Expression) (int, bool) {
+	k, ok := constValue(c, e)
+	if !ok {
+		return 0, false
+	}
+	if k.Huge || k.Int < 0 || k.Int >= int64(a.N) {
+		panic("Index " + k.String() + " out of range")
+	}
+	return int(k.Int), true
+}
+
+// undecayedType returns the type of e, without decaying arrays to pointers
+func undecayedType(c *Compiler, e Expression) Type {
+	if lv, ok := e.(LValue); ok {
+		return lv.storageType(c)
+	}
+	return e.TypeOf(c)
+}
+
+// checkCond panics if e cannot be used as a truth value, and returns its type
+func checkCond(c *Compiler, what string, e Expression) NumericType {
+	ty := e.TypeOf(c)
+	if c.StrictBool {
+		if !ty.Concrete().Equals(TypeBool) {
+			panic(what + " must be Bool, not " + ty.Format(0))
+		}
+	} else if _, ok := ty.Concrete().(NumericType); !ok || isFloat(ty) {
+		panic(what + " must be Bool, an integer or a pointer, not " + ty.Format(0))
+	}
+	return ty.Concrete().(NumericType)
+}
+
+// checkBoolOp panics if op may not be used on operands of type ty
+func checkBoolOp(op fmt.Stringer, ty Type) {
+	if ty.Concrete().Equals(TypeBool) {
+		panic("Operator " + op.String() + " is not defined for Bool")
+	}
+}
 
-// FIXME: not and inv require integer types
 func (e PrefixExpr) TypeOf(c *Compiler) Type {
+	if e.Op == PrefNot {
+		checkCond(c, "Operand of !", e.V)
+		return TypeBool
+	}
 	ty := e.V.TypeOf(c)
 	if _, ok := ty.Concrete().(NumericType); !ok {
 		panic("Operand of prefix expression is of non-numeric type")
-	} else {
-		return ty
 	}
+	if isFloat(ty) && e.Op == PrefInv {
+		panic("Operator " + e.Op.String() + " is not defined for floating-point type " + ty.Format(0))
+	}
+	checkBoolOp(e.Op, ty)
+	return ty
 }
 
-// FIXME: all operators other than add, sub, div and mul require integer types
 // FIXME: lsh and rsh require their second argument to be an I32 or smaller
 func (e BinaryExpr) TypeOf(c *Compiler) Type {
+	ty := e.operandType(c)
+	if _, ok := ty.Concrete().(NumericType); !ok {
+		panic("Operand of binary expression is of non-numeric type " + ty.Format(0))
+	}
+	if isFloat(ty) {
+		switch e.Op {
+		case BinMod, BinOr, BinXor, BinAnd, BinShl, BinShr:
+			panic("Operator " + e.Op.String() + " is not defined for floating-point type " + ty.Format(0))
+		}
+	}
+	switch e.Op {
+	case BinCeq, BinCne, BinOr, BinXor, BinAnd:
+	default:
+		checkBoolOp(e.Op, ty)
+	}
+	if e.Op.Compare() {
+		return TypeBool
+	}
+	return ty
+}
+
+// operandType returns the type both operands of the expression are converted to
+func (e BinaryExpr) operandType(c *Compiler) Type {
 	ltyp := e.L.TypeOf(c)
 	rtyp := e.R.TypeOf(c)
 
-	if !ltyp.IsConcrete() && rtyp.IsConcrete() {
+	if _, ok := ltyp.(IntLitType); ok || (!ltyp.IsConcrete() && rtyp.IsConcrete()) {
 		ltyp, rtyp = rtyp, ltyp
 	}
 
@@ -163,25 +719,42 @@ func (e BinaryExpr) TypeOf(c *Compiler) Type {
 	} else {
 		typeCheck("binary expression", rtyp, ltyp)
 	}
+	checkRange(c, e.L, ltyp)
+	checkRange(c, e.R, ltyp)
 	return ltyp
 }
 
 func (e BooleanExpr) TypeOf(c *Compiler) Type {
-	ltyp := e.L.TypeOf(c)
-	rtyp := e.R.TypeOf(c)
-	typeCheck("boolean expression", rtyp, ltyp)
-	return ltyp
+	checkCond(c, "Operand of "+e.Op.String(), e.L)
+	checkCond(c, "Operand of "+e.Op.String(), e.R)
+	return TypeBool
 }
 
-func (_ IntegerExpr) TypeOf(c *Compiler) Type {
-	return IntLitType{}
+func (_ InitExpr) TypeOf(c *Compiler) Type {
+	panic("Initializer list used without a type")
+}
+func (_ TupleExpr) TypeOf(c *Compiler) Type {
+	panic("Multiple values used in single-value context")
+}
+func (e KeyedExpr) TypeOf(c *Compiler) Type {
+	panic("Field name " + e.Name + " used outside of an initializer list")
+}
+func (e CompositeExpr) TypeOf(c *Compiler) Type {
+	return e.Ty.Get(c)
+}
+
+func (e FuncExpr) TypeOf(c *Compiler) Type {
+	return PointerType{To: e.function().typ(c)}
+}
+
+func (e IntegerExpr) TypeOf(c *Compiler) Type {
+	return e.value().Ty
 }
-func (_ FloatExpr) TypeOf(c *Compiler) Type {
-	return FloatLitType{}
+func (e FloatExpr) TypeOf(c *Compiler) Type {
+	return e.value().Ty
 }
 func (_ StringExpr) TypeOf(c *Compiler) Type {
-	// TODO: immutable types
-	return PointerType{TypeI8}
+	return PointerType{TypeI8, true}
 }
 func (_ RuneExpr) TypeOf(c *Compiler) Type {
 	return IntLitType{}
@@ -193,14 +766,58 @@ func (name NamedTypeExpr) Get(c *Compiler) ConcreteType {
 func (ns NamespaceTypeExpr) Get(c *Compiler) ConcreteType {
 	return c.Type(ns...)
 }
+func (g GenericTypeExpr) Get(c *Compiler) ConcreteType {
+	args := make([]ConcreteType, len(g.Args))
+	for i, arg := range g.Args {
+		args[i] = arg.Get(c)
+	}
+	return g.generic(c).Instantiate(c, args)
+}
+
+// generic returns the generic type named by the expression
+func (g GenericTypeExpr) generic(c *Compiler) *GenericType {
+	switch name := g.Name.(type) {
+	case NamedTypeExpr:
+		return c.Generic(string(name))
+	case NamespaceTypeExpr:
+		return c.Generic(name...)
+	}
+	panic("[compiler bug] Invalid generic type name " + g.Name.Format(0))
+}
 func (ptr PointerTypeExpr) Get(c *Compiler) ConcreteType {
 	if ptr.To == nil {
-		return PointerType{}
+		return PointerType{Const: ptr.Const}
 	}
-	return PointerType{ptr.To.Get(c)}
+	return PointerType{ptr.To.Get(c), ptr.Const}
 }
 func (arr ArrayTypeExpr) Get(c *Compiler) ConcreteType {
-	return ArrayType{arr.Ty.Get(c), arr.N}
+	n, ok := constValue(c, arr.N)
+	if !ok || isFloat(n.Ty) {
+		panic("Array length must be a constant integer")
+	}
+	if n.Int < 0 {
+		panic("Array length must not be negative")
+	}
+	return ArrayType{arr.Ty.Get(c), int(n.Int)}
+}
+func (t TupleTypeExpr) Get(c *Compiler) ConcreteType {
+	panic("Tuple type " + t.Format(0) + " can only be used as a function result")
+}
+
+// resultType returns the type of a function result, which unlike other types may hold multiple values
+func resultType(c *Compiler, t TypeExpr) ConcreteType {
+	tuple, ok := t.(TupleTypeExpr)
+	if !ok {
+		return t.Get(c)
+	}
+	types := make(TupleType, len(tuple))
+	for i, ty := range tuple {
+		types[i] = ty.Get(c)
+	}
+	return types
+}
+func (s SliceTypeExpr) Get(c *Compiler) ConcreteType {
+	return SliceType{s.Ty.Get(c), s.Const}
 }
 func (fun FuncTypeExpr) Get(c *Compiler) ConcreteType {
 	params := make([]ConcreteType, len(fun.Param))
@@ -212,7 +829,7 @@ func (fun FuncTypeExpr) Get(c *Compiler) ConcreteType {
 	}
 	var ret ConcreteType
 	if fun.Ret != nil {
-		ret = fun.Ret.Get(c)
+		ret = resultType(c, fun.Ret)
 		if _, ok := ret.Concrete().(ArrayType); ok {
 			panic("Cannot use an array type as a function return")
 		}
@@ -228,9 +845,55 @@ func compositeGet(c *Compiler, composite []VarDecl) compositeType {
 	}
 	return compositeType(fields)
 }
+func (e EnumTypeExpr) Get(c *Compiler) ConcreteType {
+	panic("Enum types must be named by a type definition")
+}
+
+// get compiles an enum type named name
+func (e EnumTypeExpr) get(c *Compiler, name string) EnumType {
+	ty, ok := e.Ty.Get(c).Concrete().(PrimitiveType)
+	if !ok || ty.Float() || ty == TypeBool {
+		panic("Underlying type of enum " + name + " must be an integer type")
+	}
+
+	enum := EnumType{name, ty, make([]EnumMember, len(e.Members))}
+	next := Constant{Ty: ty}
+	for i, m := range e.Members {
+		if m.Value != nil {
+			k, ok := constValue(c, m.Value)
+			if !ok {
+				panic("Value of enum member " + m.Name + " is not constant: " + m.Value.Format(0))
+			}
+			typeCheck("enum member", k.Ty, ty)
+			next = k
+		}
+		if next.Convert(ty).Int != next.Int {
+			panic("Value of enum member " + m.Name + " does not fit in " + ty.Format(0))
+		}
+
+		enum.Members[i] = EnumMember{m.Name, next.Int}
+		next = Constant{Ty: ty, Int: next.Int + 1}
+	}
+	return enum
+}
+
 func (s StructTypeExpr) Get(c *Compiler) ConcreteType {
 	return StructType{compositeGet(c, []VarDecl(s))}
 }
 func (u UnionTypeExpr) Get(c *Compiler) ConcreteType {
 	return UnionType{compositeGet(c, []VarDecl(u))}
 }
+func (v VariantTypeExpr) Get(c *Compiler) ConcreteType {
+	return VariantType{compositeGet(c, []VarDecl(v))}
+}
+func (iface InterfaceTypeExpr) Get(c *Compiler) ConcreteType {
+	methods := make([]Field, len(iface))
+	for i, m := range iface {
+		fn := FuncTypeExpr{Ret: m.Ret}
+		for _, param := range m.Param {
+			fn.Param = append(fn.Param, param.Ty)
+		}
+		methods[i] = Field{m.Name, fn.Get(c)}
+	}
+	return InterfaceType{compositeType(methods)}
+}
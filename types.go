@@ -9,6 +9,31 @@ func Compatible(a, b Type) bool {
 	if a.Equals(b) || b.Equals(a) {
 		return true
 	}
+	if p, ok := a.(PointerType); ok && !p.Const {
+		// Mutable pointers may be used as const pointers, but not the other way round
+		p.Const = true
+		if p.Equals(b) || b.Equals(p) {
+			return true
+		}
+	}
+	if s, ok := a.(SliceType); ok && !s.Const {
+		// Likewise for slices
+		s.Const = true
+		if s.Equals(b) || b.Equals(s) {
+			return true
+		}
+	}
+	if b.IsConcrete() {
+		if _, ok := b.Concrete().(InterfaceType); ok {
+			// Pointers to named types may implement interfaces
+			// Their methods are checked when the pointer is converted
+			p, ok := a.Concrete().(PointerType)
+			if ok && !p.Const {
+				_, ok = p.To.(NamedType)
+			}
+			return ok
+		}
+	}
 	switch a.(type) {
 	case IntLitType:
 		switch b.(type) {
@@ -19,16 +44,26 @@ func Compatible(a, b Type) bool {
 		switch b.(type) {
 		case IntLitType, FloatLitType:
 			return true
+		case NumericType:
+			return isFloat(b)
 		}
 	case NumericType:
 		switch b.(type) {
 		case IntLitType:
 			return true
+		case FloatLitType:
+			return isFloat(a)
 		}
 	}
 	return false
 }
 
+// isFloat returns true if ty is a floating-point type
+func isFloat(ty Type) bool {
+	p, ok := ty.Concrete().(PrimitiveType)
+	return ok && p.Float()
+}
+
 type Type interface {
 	Equals(other Type) bool
 	IsConcrete() bool
@@ -45,6 +80,8 @@ type ConcreteType interface {
 	IRBaseTypeName() byte
 	// Generate code to zero a value of the type
 	GenZero(c *Compiler, loc Operand)
+	// Generate code to copy a value of the type from src to dst
+	GenCopy(c *Compiler, dst, src Operand)
 }
 
 // TypeMetrics stores the size and alignment of a type. If a type's metrics are zero, a value of that type cannot be created.
@@ -58,9 +95,10 @@ type NumericType interface {
 }
 
 type Namespace struct {
-	Name string
-	Vars map[string]Type
-	Typs map[string]*ConcreteType
+	Name   string
+	Vars   map[string]Type
+	Typs   map[string]*ConcreteType
+	Consts map[string]*Constant
 }
 
 func (ns Namespace) IsConcrete() bool         { return false }
@@ -68,6 +106,23 @@ func (a Namespace) Equals(other Type) bool    { panic("Namespace used as value")
 func (ns Namespace) Concrete() ConcreteType   { panic("Namespace used as value") }
 func (ns Namespace) Format(indent int) string { panic("Namespace used as value") }
 
+// GenericFunc is the type of a generic function, which is instantiated when it is called
+type GenericFunc struct {
+	Function
+	ns []Namespace // The namespace stack the function was declared in
+}
+
+func (_ GenericFunc) IsConcrete() bool { return false }
+func (f GenericFunc) Equals(other Type) bool {
+	panic("Generic function " + f.Name + " used as value")
+}
+func (f GenericFunc) Concrete() ConcreteType {
+	panic("Generic function " + f.Name + " used as value")
+}
+func (f GenericFunc) Format(indent int) string {
+	return "generic function " + f.Name
+}
+
 // The type of integral numeric literals
 type IntLitType struct{}
 
@@ -123,6 +178,10 @@ func (p PrimitiveType) Signed() bool {
 	panic("Invalid primitive type")
 }
 
+func (p PrimitiveType) Float() bool {
+	return p == TypeF64 || p == TypeF32
+}
+
 func (t PrimitiveType) IsConcrete() bool {
 	return true
 }
@@ -229,18 +288,19 @@ const (
 )
 
 type PointerType struct {
-	To ConcreteType
+	To    ConcreteType
+	Const bool // The pointed-to value may not be modified through the pointer
 }
 
 func (a PointerType) Equals(other Type) bool {
 	if b, ok := other.(NamedType); ok {
-		if b, ok := b.ConcreteType.(PointerType); ok {
-			return a.To == nil || b.To == nil
+		if b, ok := b.Concrete().(PointerType); ok {
+			return a.Const == b.Const && (a.To == nil || b.To == nil)
 		}
 	}
 	b, ok := other.(PointerType)
 	// nil To means generic pointer, which is compatible with every pointer type
-	return ok && (a.To == nil || b.To == nil || a.To.Equals(b.To))
+	return ok && a.Const == b.Const && (a.To == nil || b.To == nil || a.To.Equals(b.To))
 }
 func (_ PointerType) Signed() bool {
 	return false
@@ -256,8 +316,14 @@ func (_ PointerType) Metrics() TypeMetrics {
 }
 func (p PointerType) Format(indent int) string {
 	var t string
+	if p.Const {
+		t = "const"
+		if p.To != nil {
+			t += " "
+		}
+	}
 	if p.To != nil {
-		t = p.To.Format(indent)
+		t += p.To.Format(indent)
 	}
 	return "[" + t + "]"
 }
@@ -273,12 +339,15 @@ type ArrayType struct {
 	N  int
 }
 
-func (_ ArrayType) Equals(_ Type) bool     { panic("Use of array type") }
+func (a ArrayType) Equals(other Type) bool {
+	b, ok := other.(ArrayType)
+	return ok && a.N == b.N && a.Ty.Equals(b.Ty)
+}
 func (_ ArrayType) IsConcrete() bool       { return true }
 func (a ArrayType) Concrete() ConcreteType { return a }
 func (a ArrayType) IRBaseTypeName() byte   { return 0 }
 func (a ArrayType) ptr() PointerType {
-	return PointerType{a.Ty}
+	return PointerType{To: a.Ty}
 }
 func (a ArrayType) Metrics() TypeMetrics {
 	m := a.Ty.Metrics()
@@ -292,6 +361,44 @@ func (a ArrayType) IRTypeName(c *Compiler) string {
 	return c.CompositeType(CompositeLayout{{a.Ty.IRTypeName(c), a.N}})
 }
 
+// SliceType is a pointer to a sequence of values along with its length
+type SliceType struct {
+	Ty    ConcreteType
+	Const bool // The values may not be modified through the slice
+}
+
+func (a SliceType) Equals(other Type) bool {
+	b, ok := other.(SliceType)
+	return ok && a.Const == b.Const && a.Ty.Equals(b.Ty)
+}
+func (_ SliceType) IsConcrete() bool       { return true }
+func (s SliceType) Concrete() ConcreteType { return s }
+func (s SliceType) IRBaseTypeName() byte   { return 0 }
+func (s SliceType) Metrics() TypeMetrics {
+	return s.repr().Metrics()
+}
+func (s SliceType) Format(indent int) string {
+	if s.Const {
+		return "[const " + s.Ty.Format(indent) + " ..]"
+	}
+	return "[" + s.Ty.Format(indent) + " ..]"
+}
+func (s SliceType) IRTypeName(c *Compiler) string {
+	return s.repr().IRTypeName(c)
+}
+func (s SliceType) Field(name string) ConcreteType {
+	return s.repr().Field(name)
+}
+func (s SliceType) Offset(name string) int {
+	return s.repr().Offset(name)
+}
+func (s SliceType) repr() StructType {
+	return StructType{compositeType{
+		{"ptr", PointerType{s.Ty, s.Const}},
+		{"len", TypeU64},
+	}}
+}
+
 type FuncType struct {
 	Var   bool // true if the function uses C-style varags
 	Param []ConcreteType
@@ -303,7 +410,7 @@ func (a FuncType) Equals(other Type) bool {
 	if !ok {
 		return false
 	}
-	if a.Ret != b.Ret && !a.Ret.Equals(b.Ret) {
+	if (a.Ret == nil) != (b.Ret == nil) || a.Ret != nil && !a.Ret.Equals(b.Ret) {
 		return false
 	}
 	if len(a.Param) != len(b.Param) {
@@ -343,13 +450,54 @@ func (_ FuncType) IRBaseTypeName() byte {
 	return 0
 }
 
+// TupleType holds the values returned by a function with multiple results
+type TupleType []ConcreteType
+
+func (a TupleType) Equals(other Type) bool {
+	b, ok := other.(TupleType)
+	if !ok || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+func (_ TupleType) IsConcrete() bool       { return true }
+func (t TupleType) Concrete() ConcreteType { return t }
+func (_ TupleType) IRBaseTypeName() byte   { return 0 }
+func (t TupleType) Metrics() TypeMetrics {
+	return t.repr().Metrics()
+}
+func (t TupleType) Format(indent int) string {
+	types := make([]string, len(t))
+	for i, ty := range t {
+		types[i] = ty.Format(indent)
+	}
+	return "(" + strings.Join(types, ", ") + ")"
+}
+func (t TupleType) IRTypeName(c *Compiler) string {
+	return t.repr().IRTypeName(c)
+}
+
+// repr returns the struct used to store a tuple
+func (t TupleType) repr() StructType {
+	fields := make(compositeType, len(t))
+	for i, ty := range t {
+		fields[i] = Field{strconv.Itoa(i), ty}
+	}
+	return StructType{fields}
+}
+
 type NamedType struct {
-	ConcreteType
 	Name string
+	ty   *ConcreteType // The underlying type, which is filled in once its definition is compiled
 }
 
 func (a NamedType) Equals(other Type) bool {
-	if ap, ok := a.ConcreteType.(PointerType); ok {
+	if ap, ok := a.Concrete().(PointerType); ok {
 		return ap.Equals(other)
 	}
 	b, ok := other.(NamedType)
@@ -358,6 +506,95 @@ func (a NamedType) Equals(other Type) bool {
 func (a NamedType) Format(indent int) string {
 	return a.Name
 }
+func (_ NamedType) IsConcrete() bool {
+	return true
+}
+func (t NamedType) Concrete() ConcreteType {
+	return t.underlying().Concrete()
+}
+func (t NamedType) Metrics() TypeMetrics {
+	return t.underlying().Metrics()
+}
+func (t NamedType) IRTypeName(c *Compiler) string {
+	return t.underlying().IRTypeName(c)
+}
+func (t NamedType) IRBaseTypeName() byte {
+	return t.underlying().IRBaseTypeName()
+}
+func (t NamedType) underlying() ConcreteType {
+	if *t.ty == nil {
+		panic("Type " + t.Name + " used before its definition is complete")
+	}
+	return *t.ty
+}
+
+// embeddedIn returns true if a value of type ty contains a value of the named type
+func (t NamedType) embeddedIn(ty ConcreteType) bool {
+	switch ty := ty.(type) {
+	case NamedType:
+		return ty.ty == t.ty || *ty.ty != nil && t.embeddedIn(*ty.ty)
+	case ArrayType:
+		return t.embeddedIn(ty.Ty)
+	case StructType:
+		return t.embeddedInFields(ty.compositeType)
+	case UnionType:
+		return t.embeddedInFields(ty.compositeType)
+	case VariantType:
+		return t.embeddedInFields(ty.compositeType)
+	}
+	return false
+}
+func (t NamedType) embeddedInFields(fields compositeType) bool {
+	for _, field := range fields {
+		if t.embeddedIn(field.Ty) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnumType is an integer type with a set of named values
+// Like NamedType, enums are only equal to themselves
+type EnumType struct {
+	Name    string
+	Ty      PrimitiveType // Underlying integer type
+	Members []EnumMember
+}
+type EnumMember struct {
+	Name  string
+	Value int64
+}
+
+func (a EnumType) Equals(other Type) bool {
+	switch b := other.(type) {
+	case EnumType:
+		return a.Name == b.Name
+	case NamedType:
+		return a.Name == b.Name
+	}
+	return false
+}
+func (e EnumType) Signed() bool {
+	return e.Ty.Signed()
+}
+func (_ EnumType) IsConcrete() bool {
+	return true
+}
+func (e EnumType) Concrete() ConcreteType {
+	return e
+}
+func (e EnumType) Metrics() TypeMetrics {
+	return e.Ty.Metrics()
+}
+func (e EnumType) Format(indent int) string {
+	return e.Name
+}
+func (e EnumType) IRTypeName(c *Compiler) string {
+	return e.Ty.IRTypeName(c)
+}
+func (e EnumType) IRBaseTypeName() byte {
+	return e.Ty.IRBaseTypeName()
+}
 
 type Field struct {
 	Name string
@@ -366,6 +603,11 @@ type Field struct {
 type compositeType []Field
 type StructType struct{ compositeType }
 type UnionType struct{ compositeType }
+type VariantType struct{ compositeType }
+
+// InterfaceType holds a pointer to a value along with a table of the methods of its type
+// The fields are the methods of the interface, excluding their receiver
+type InterfaceType struct{ compositeType }
 
 type CompositeType interface {
 	Field(name string) ConcreteType
@@ -487,8 +729,21 @@ func (a UnionType) Equals(other Type) bool {
 func (u UnionType) Concrete() ConcreteType {
 	return u
 }
-func (u UnionType) Metrics() TypeMetrics {
-	return u.largest().Ty.Metrics()
+func (u UnionType) Metrics() (m TypeMetrics) {
+	// Like C, the alignment is that of the most aligned member, which may not be the largest
+	for _, field := range u.compositeType {
+		fm := field.Ty.Metrics()
+		if fm.Size > m.Size {
+			m.Size = fm.Size
+		}
+		if fm.Align > m.Align {
+			m.Align = fm.Align
+		}
+	}
+	if m.Align > 0 {
+		m.Size = -(-m.Size & -m.Align) // Align upwards
+	}
+	return
 }
 func (u UnionType) Format(indent int) string {
 	return "union " + u.format(indent)
@@ -496,20 +751,103 @@ func (u UnionType) Format(indent int) string {
 func (u UnionType) IRTypeName(c *Compiler) string {
 	return c.CompositeType(u.layout(c))
 }
-func (u UnionType) layout(c *Compiler) CompositeLayout {
-	return CompositeLayout{{u.largest().Ty.IRTypeName(c), 1}}
-}
-func (u UnionType) largest() (f Field) {
-	fs := 0
+func (u UnionType) layout(c *Compiler) TypeLayout {
+	var layout UnionLayout
+	seen := map[string]bool{}
 	for _, field := range u.compositeType {
-		fsiz := field.Ty.Metrics().Size
-		if fsiz > fs {
-			f = field
-			fs = fsiz
+		ty := field.Ty.IRTypeName(c)
+		if !seen[ty] {
+			seen[ty] = true
+			layout = append(layout, CompositeLayout{{ty, 1}})
 		}
 	}
-	return
+	if len(layout) == 1 {
+		// Every member has the same layout, so we don't need a union
+		return layout[0]
+	}
+	return layout
 }
 func (_ UnionType) Offset(name string) int {
 	return 0
 }
+
+func (a VariantType) Equals(other Type) bool {
+	b, ok := other.(VariantType)
+	return ok && a.equals(b.compositeType)
+}
+func (v VariantType) Concrete() ConcreteType {
+	return v
+}
+func (v VariantType) Metrics() TypeMetrics {
+	return v.repr().Metrics()
+}
+func (v VariantType) Format(indent int) string {
+	return "variant " + v.format(indent)
+}
+func (v VariantType) IRTypeName(c *Compiler) string {
+	return v.repr().IRTypeName(c)
+}
+func (v VariantType) Offset(name string) int {
+	return v.repr().Offset("value")
+}
+
+// repr returns the struct used to store a variant: a tag holding the index of the active member, followed by the payload
+func (v VariantType) repr() StructType {
+	return StructType{compositeType{
+		{"tag", TypeU32},
+		{"value", UnionType{v.compositeType}},
+	}}
+}
+
+func (a InterfaceType) Equals(other Type) bool {
+	b, ok := other.(InterfaceType)
+	if !ok || len(a.compositeType) != len(b.compositeType) {
+		return false
+	}
+	for i, m := range a.compositeType {
+		if m.Name != b.compositeType[i].Name || !m.Ty.Equals(b.compositeType[i].Ty) {
+			return false
+		}
+	}
+	return true
+}
+func (iface InterfaceType) Concrete() ConcreteType {
+	return iface
+}
+func (iface InterfaceType) Metrics() TypeMetrics {
+	return iface.repr().Metrics()
+}
+func (iface InterfaceType) Format(indent int) string {
+	return "interface " + iface.format(indent)
+}
+func (iface InterfaceType) IRTypeName(c *Compiler) string {
+	return iface.repr().IRTypeName(c)
+}
+
+// repr returns the struct used to store an interface: a pointer to the value, followed by a pointer to its vtable
+func (iface InterfaceType) repr() StructType {
+	return StructType{compositeType{
+		{"data", PointerType{}},
+		{"vtable", PointerType{}},
+	}}
+}
+
+// Method returns the index of the named method in the vtable, or -1 if there is no such method
+func (iface InterfaceType) Method(name string) int {
+	for i, m := range iface.compositeType {
+		if m.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Tag returns the tag value of the named member, or -1 if there is no such member
+func (v VariantType) Tag(name string) int {
+	for i, field := range v.compositeType {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}